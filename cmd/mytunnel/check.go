@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial"
+	"golang.org/x/crypto/ssh"
+)
+
+func check(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	timeout := flags.Duration("timeout", 30*time.Second, "overall timeout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return 2
+	}
+	addr := flags.Arg(0)
+
+	config, err := dial.ParseAddr(addr)
+	if err != nil {
+		report(stdout, "parse", 0, err, "")
+		return 1
+	}
+	report(stdout, "parse", 0, nil, describe(config))
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	var (
+		stage = time.Now()
+		since = func() time.Duration {
+			now := time.Now()
+			took := now.Sub(stage)
+			stage = now
+			return took
+		}
+		trace = &dial.Trace{
+			ConnectDone: func(addr string, err error) {
+				report(stdout, "connect", since(), err, addr)
+			},
+			HandshakeDone: func(info dial.HandshakeInfo, err error) {
+				report(stdout, "handshake", since(), err, describeHandshake(info))
+			},
+			ChannelOpenDone: func(network, addr string, err error) {
+				report(stdout, "channel", since(), err, network+" "+addr)
+			},
+		}
+	)
+	ctx = dial.WithTrace(ctx, trace)
+
+	if config.Net == "" {
+		// no target, check ssh only
+		session, err := dial.NewSessionConfig(ctx, config)
+		if err != nil {
+			_, _ = fmt.Fprintln(stderr, err)
+			return 1
+		}
+		report(stdout, "session", since(), nil, "")
+		_ = session.Close()
+		return 0
+	}
+
+	conn, err := dial.DialConfig(ctx, config)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	_ = conn.Close()
+	return 0
+}
+
+func report(w io.Writer, stage string, took time.Duration, err error, details string) {
+	status := "ok"
+	if err != nil {
+		status, details = "FAIL", err.Error()
+	}
+	_, _ = fmt.Fprintf(w, "%-10s %-4s %8s  %s\n", stage, status, took.Round(time.Millisecond), details)
+}
+
+func describe(config dial.Config) string {
+	port := config.Port
+	if port == 0 {
+		port = dial.DefaultPort
+	}
+	res := fmt.Sprintf("%s@%s", config.Username, net.JoinHostPort(config.Host, fmt.Sprint(port)))
+	if config.Net != "" {
+		res += fmt.Sprintf(" -> %s %s", config.Net, config.Addr)
+	}
+	return res
+}
+
+func describeHandshake(info dial.HandshakeInfo) string {
+	res := info.ServerVersion
+	if info.AuthMethod != "" {
+		res += ", auth: " + info.AuthMethod
+	}
+	if info.PublicKey != nil {
+		res += " " + info.PublicKey.Type() + " " + ssh.FingerprintSHA256(info.PublicKey)
+	}
+	if info.KeySource != "" {
+		res += " (" + info.KeySource + ")"
+	}
+	return res
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/TelpeNight/mytunnel/dial"
+	"golang.org/x/crypto/ssh"
+)
+
+func execCmd(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) < 3 || args[1] != "--" {
+		_, _ = fmt.Fprint(stderr, usage)
+		return 2
+	}
+	addr, cmd := args[0], shellJoin(args[2:])
+
+	session, err := dial.NewSession(ctx, addr)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer func() { _ = session.Close() }()
+
+	// not session.Stdin: Wait would block until stdin is closed, even when the command has exited
+	remoteStdin, err := session.StdinPipe()
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	go func() {
+		_, _ = io.Copy(remoteStdin, stdin)
+		_ = remoteStdin.Close()
+	}()
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	err = session.RunContext(ctx, cmd)
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus()
+	default:
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+}
+
+// shellJoin quotes args for the remote shell, so they are passed to the command as is, like in a local exec
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%_+=:,./-") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/TelpeNight/mytunnel/dial"
+)
+
+// explain prints resolved config and warnings, MYTUNNEL_* env is applied. Exit code is 1, if there are warnings.
+func explain(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return 2
+	}
+	config, err := dial.ParseAddr(args[0])
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	explanation := config.WithEnv().Explain()
+	_, _ = fmt.Fprint(stdout, explanation)
+	if len(explanation.Warnings) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/TelpeNight/mytunnel/dial"
+)
+
+func forward(ctx context.Context, args []string, stderr io.Writer) int {
+	if len(args) != 2 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return 2
+	}
+	local, addr := args[0], args[1]
+	config, err := dial.ParseAddr(addr)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	network := "tcp"
+	if strings.Contains(local, "/") {
+		network = "unix"
+	}
+	listener, err := net.Listen(network, local)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(stderr, "forwarding %s -> %s\n", listener.Addr(), describe(config))
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0
+			}
+			_, _ = fmt.Fprintln(stderr, err)
+			return 1
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { _ = conn.Close() }()
+			if err := pipe(ctx, conn, config); err != nil {
+				_, _ = fmt.Fprintf(stderr, "%s: %s\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func pipe(ctx context.Context, conn net.Conn, config dial.Config) error {
+	remote, err := dial.DialConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = remote.Close() }()
+	// copies block until a side hangs up, so forward can't exit with open connections otherwise
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+		_ = remote.Close()
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(remote, conn)
+		_ = remote.Close()
+	}()
+	_, _ = io.Copy(conn, remote)
+	_ = conn.Close()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return nil
+}
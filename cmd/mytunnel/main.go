@@ -0,0 +1,54 @@
+// Command mytunnel checks and explains dial addresses, forwards local connections and runs remote commands through ssh.
+//
+//	mytunnel check <addr>
+//	mytunnel explain <addr>
+//	mytunnel forward <local> <addr>
+//	mytunnel exec <addr> -- <cmd> [args...]
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+const usage = `usage:
+  mytunnel check [-timeout 30s] <addr>
+  mytunnel explain <addr>
+  mytunnel forward <local> <addr>
+  mytunnel exec <addr> -- <cmd> [args...]
+`
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "check":
+		return check(ctx, args, stdout, stderr)
+	case "explain":
+		return explain(args, stdout, stderr)
+	case "forward":
+		return forward(ctx, args, stderr)
+	case "exec":
+		return execCmd(ctx, args, stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		_, _ = fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		_, _ = fmt.Fprintf(stderr, "unknown command %q\n%s", cmd, usage)
+		return 2
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+)
+
+func TestCheck(t *testing.T) {
+	srv := newTestServer(t)
+	target := listenDiscard(t)
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"check", fmt.Sprintf("user:pass@%s/%s", srv.Addr, target)}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("check exit code = %d, stderr: %s", code, stderr.String())
+	}
+	for _, stage := range []string{"parse", "connect", "handshake", "channel"} {
+		if !strings.Contains(stdout.String(), stage) {
+			t.Errorf("check output has no %s stage:\n%s", stage, stdout.String())
+		}
+	}
+	if !strings.Contains(stdout.String(), "auth: password") {
+		t.Errorf("check output has no auth method:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	code = run(context.Background(), []string{"check", fmt.Sprintf("user:wrong@%s/%s", srv.Addr, target)}, nil, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("check with wrong password exit code = %d, want 1", code)
+	}
+	if !strings.Contains(stdout.String(), "FAIL") {
+		t.Errorf("check output has no failed stage:\n%s", stdout.String())
+	}
+}
+
+func TestExec(t *testing.T) {
+	srv := newTestServer(t)
+
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader("input")
+	code := run(context.Background(), []string{"exec", "user:pass@" + srv.Addr, "--", "echo", "hello"}, stdin, &stdout, &stderr)
+	if code != 3 {
+		t.Fatalf("exec exit code = %d, want 3, stderr: %s", code, stderr.String())
+	}
+	if stdout.String() != "echo hello" {
+		t.Errorf("exec stdout = %q", stdout.String())
+	}
+	if stderr.String() != "input" {
+		t.Errorf("exec stderr = %q", stderr.String())
+	}
+}
+
+func Test_shellJoin(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"echo", "hello"}, "echo hello"},
+		{[]string{"mysql", "-e", "select 1"}, "mysql -e 'select 1'"},
+		{[]string{"echo", "it's", ""}, `echo 'it'\''s' ''`},
+		{[]string{"ls", "$HOME;", "*"}, `ls '$HOME;' '*'`},
+	}
+	for _, tt := range tests {
+		if got := shellJoin(tt.args); got != tt.want {
+			t.Errorf("shellJoin(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	srv := newTestServer(t)
+	target := listenEcho(t)
+
+	tests := []struct {
+		name string
+		// keepOpen cancels forward with an open connection
+		keepOpen bool
+	}{
+		{name: "closed connection"},
+		{name: "open connection", keepOpen: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := filepath.Join(t.TempDir(), "forward.sock")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan int)
+			var stderr bytes.Buffer
+			go func() {
+				done <- run(ctx, []string{"forward", local, fmt.Sprintf("user:pass@%s/%s", srv.Addr, target)}, nil, io.Discard, &stderr)
+			}()
+
+			var (
+				conn net.Conn
+				err  error
+			)
+			for range 100 {
+				if conn, err = net.Dial("unix", local); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err != nil {
+				cancel()
+				<-done
+				t.Fatalf("forward is not listening: %v, stderr: %s", err, stderr.String())
+			}
+			defer func() { _ = conn.Close() }()
+			if _, err = conn.Write([]byte("hello")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			buf := make([]byte, 5)
+			if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+				t.Errorf("ReadFull() = %q, %v, want hello", buf, err)
+			}
+			if !tt.keepOpen {
+				_ = conn.Close()
+			}
+
+			cancel()
+			select {
+			case code := <-done:
+				if code != 0 {
+					t.Errorf("forward exit code = %d, want 0, stderr: %s", code, stderr.String())
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("forward has not exited after cancel")
+			}
+			if tt.keepOpen {
+				if _, err = conn.Read(buf); err == nil {
+					t.Errorf("Read() after cancel: expected closed connection")
+				}
+			}
+		})
+	}
+	if srv.Channels() != 2 {
+		t.Errorf("Channels() = %d, want 2", srv.Channels())
+	}
+}
+
+func TestExplain(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"explain", "user@host/db.internal:3306?ServerAliveInterval=10"}, nil, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("explain exit code = %d, want 1", code)
+	}
+	for _, want := range []string{"user@host:22", "unix /db.internal:3306", "interval 10s", "host-as-path"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("explain output has no %q:\n%s", want, stdout.String())
+		}
+	}
+
+	stdout.Reset()
+	code = run(context.Background(), []string{"explain", "user@host/127.0.0.1:3306"}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Errorf("explain exit code = %d, want 0\n%s", code, stdout.String())
+	}
+
+	t.Setenv("MYTUNNEL_USER", "envuser")
+	t.Setenv("MYTUNNEL_SERVER_ALIVE_INTERVAL", "5")
+	stdout.Reset()
+	code = run(context.Background(), []string{"explain", "host/127.0.0.1:3306"}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Errorf("explain exit code = %d, want 0\n%s", code, stdout.String())
+	}
+	for _, want := range []string{"envuser@host:22", "interval 5s"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("explain output has no %q:\n%s", want, stdout.String())
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	for _, args := range [][]string{nil, {"unknown"}, {"exec", "user@host", "cmd"}, {"forward", "127.0.0.1:0"}} {
+		if code := run(context.Background(), args, nil, &stdout, &stderr); code != 2 {
+			t.Errorf("run(%q) exit code = %d, want 2", args, code)
+		}
+	}
+}
+
+func newTestServer(t *testing.T) *dialtest.Server {
+	t.Helper()
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+		Exec: func(cmd string, stdin io.Reader, stdout, stderr io.Writer) int {
+			_, _ = io.WriteString(stdout, cmd)
+			_, _ = io.Copy(stderr, stdin)
+			return 3
+		},
+	})
+	srv.SetupHome(t, nil)
+	return srv
+}
+
+func listenEcho(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func listenDiscard(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
@@ -0,0 +1,327 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentPath returns the agent socket from IdentityAgent param or SSH_AUTH_SOCK. Empty path means no agent.
+func agentPath(config Config, home string) (string, error) {
+	vals := config.Params["IdentityAgent"]
+	switch len(vals) {
+	case 0:
+		return os.Getenv("SSH_AUTH_SOCK"), nil
+	case 1:
+	default:
+		return "", errors.New("multiple values for IdentityAgent")
+	}
+	switch vals[0] {
+	case "none":
+		return "", nil
+	case "SSH_AUTH_SOCK":
+		return os.Getenv("SSH_AUTH_SOCK"), nil
+	}
+	return os.ExpandEnv(expandHome(vals[0], home)), nil
+}
+
+// agentKeyring is a live connection to ssh-agent. It is connected lazily and reconnects, if the agent restarts.
+// Keyring lives as long as the ssh client, which it has authenticated.
+type agentKeyring struct {
+	path string
+
+	mu     sync.Mutex
+	conn   *agentConn
+	client agent.ExtendedAgent
+	closed bool
+}
+
+var errAgentClosed = errors.New("ssh agent keyring is closed")
+
+func newAgentKeyring(path string) *agentKeyring {
+	return &agentKeyring{path: path}
+}
+
+func (k *agentKeyring) agent(ctx context.Context) (*agentConn, agent.ExtendedAgent, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed {
+		return nil, nil, errAgentClosed
+	}
+	if k.client != nil {
+		return k.conn, k.client, nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", k.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ssh agent: %w", err)
+	}
+	k.conn = &agentConn{Conn: conn}
+	k.client = agent.NewClient(k.conn)
+	return k.conn, k.client, nil
+}
+
+// agentConn remembers io errors. agent client formats them with %v, so they can't be checked by the returned error.
+type agentConn struct {
+	net.Conn
+	failed atomic.Bool
+	// mu serializes calls, the deadline is shared by the connection
+	mu sync.Mutex
+}
+
+// do runs f with the connection deadline set. A timed out call fails the connection, the response may be still unread.
+func (c *agentConn) do(deadline time.Time, f func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.SetDeadline(deadline)
+	defer func() { _ = c.SetDeadline(time.Time{}) }()
+	return f()
+}
+
+func (c *agentConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		c.failed.Store(true)
+	}
+	return n, err
+}
+
+func (c *agentConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if err != nil {
+		c.failed.Store(true)
+	}
+	return n, err
+}
+
+// reset drops the broken connection, so the next call reconnects. It reports false, if the connection is fine.
+func (k *agentKeyring) reset(client agent.ExtendedAgent) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.client != client {
+		// already reconnected by another call
+		return true
+	}
+	if !k.conn.failed.Load() {
+		return false
+	}
+	_ = k.conn.Close()
+	k.conn, k.client = nil, nil
+	return true
+}
+
+// agentTimeout limits every agent call, so a hung agent doesn't block handshakes and forwarded agent requests forever
+const agentTimeout = 30 * time.Second
+
+// call runs f with the agent until ctx deadline or agentTimeout. It reconnects once, if the connection is broken.
+func (k *agentKeyring) call(ctx context.Context, f func(a agent.ExtendedAgent) error) error {
+	ctx, cancel := context.WithTimeout(ctx, agentTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	for attempt := 0; ; attempt++ {
+		conn, client, err := k.agent(ctx)
+		if err != nil {
+			return err
+		}
+		err = conn.do(deadline, func() error { return f(client) })
+		if err == nil || !k.reset(client) || attempt > 0 || ctx.Err() != nil {
+			return err
+		}
+		logger().Debug("mytunnel/dial: ssh agent connection is broken, reconnecting", "err", err)
+	}
+}
+
+func (k *agentKeyring) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.closed = true
+	if k.conn == nil {
+		return nil
+	}
+	err := k.conn.Close()
+	k.conn, k.client = nil, nil
+	return err
+}
+
+// signers lists agent keys. Signing is done by the agent at the time of Sign call.
+func (k *agentKeyring) signers(ctx context.Context) ([]ssh.Signer, error) {
+	var keys []*agent.Key
+	err := k.call(ctx, func(a agent.ExtendedAgent) error {
+		var err error
+		keys, err = a.List()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, key := range keys {
+		pub, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, &agentSigner{ctx: ctx, keyring: k, pub: pub})
+	}
+	return signers, nil
+}
+
+type agentSigner struct {
+	// ctx is the handshake context, ssh.Signer has none
+	ctx     context.Context
+	keyring *agentKeyring
+	pub     ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+// certKeyAlgos maps certificate types to algorithms of their keys.
+// x/crypto signs with a certificate using the key algorithm, e.g. ssh-ed25519 for ssh-ed25519-cert-v01@openssh.com.
+var certKeyAlgos = map[string]string{
+	ssh.CertAlgoRSAv01:        ssh.KeyAlgoRSA,
+	ssh.CertAlgoDSAv01:        ssh.KeyAlgoDSA,
+	ssh.CertAlgoECDSA256v01:   ssh.KeyAlgoECDSA256,
+	ssh.CertAlgoECDSA384v01:   ssh.KeyAlgoECDSA384,
+	ssh.CertAlgoECDSA521v01:   ssh.KeyAlgoECDSA521,
+	ssh.CertAlgoSKECDSA256v01: ssh.KeyAlgoSKECDSA256,
+	ssh.CertAlgoED25519v01:    ssh.KeyAlgoED25519,
+	ssh.CertAlgoSKED25519v01:  ssh.KeyAlgoSKED25519,
+}
+
+// keyAlgo returns the signature algorithm of the key type
+func keyAlgo(keyType string) string {
+	if algo, ok := certKeyAlgos[keyType]; ok {
+		return algo
+	}
+	return keyType
+}
+
+func (s *agentSigner) SignWithAlgorithm(_ io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case "", s.pub.Type(), keyAlgo(s.pub.Type()):
+	case ssh.KeyAlgoRSASHA256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512:
+		flags = agent.SignatureFlagRsaSha512
+	default:
+		return nil, fmt.Errorf("ssh agent: unsupported algorithm %s for %s key", algorithm, s.pub.Type())
+	}
+	var sig *ssh.Signature
+	err := s.keyring.call(s.ctx, func(a agent.ExtendedAgent) error {
+		var err error
+		sig, err = a.SignWithFlags(s.pub, data, flags)
+		return err
+	})
+	return sig, err
+}
+
+// parseForwardAgent parses ForwardAgent param: yes/no or a bool. Default is no.
+func parseForwardAgent(params map[string][]string) (bool, error) {
+	vals := params["ForwardAgent"]
+	switch len(vals) {
+	case 0:
+		return false, nil
+	case 1:
+	default:
+		return false, errors.New("multiple values for ForwardAgent")
+	}
+	switch vals[0] {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	val, err := strconv.ParseBool(vals[0])
+	if err != nil {
+		return false, fmt.Errorf("invalid value for ForwardAgent: %w", err)
+	}
+	return val, nil
+}
+
+func useForwardAgent(params map[string][]string) bool {
+	val, err := parseForwardAgent(params)
+	if err != nil {
+		logger().Warn("mytunnel/dial: invalid ForwardAgent, ignore", "err", err)
+	}
+	return val
+}
+
+// forwardedAgent serves forwarded agent channels with the live keyring connection
+type forwardedAgent struct {
+	keyring *agentKeyring
+}
+
+var _ agent.ExtendedAgent = forwardedAgent{}
+
+// do runs f with agentTimeout, requests of the remote side have no context
+func (a forwardedAgent) do(f func(a agent.ExtendedAgent) error) error {
+	return a.keyring.call(context.Background(), f)
+}
+
+func (a forwardedAgent) List() (keys []*agent.Key, err error) {
+	err = a.do(func(a agent.ExtendedAgent) error {
+		keys, err = a.List()
+		return err
+	})
+	return keys, err
+}
+
+func (a forwardedAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a forwardedAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (sig *ssh.Signature, err error) {
+	err = a.do(func(a agent.ExtendedAgent) error {
+		sig, err = a.SignWithFlags(key, data, flags)
+		return err
+	})
+	return sig, err
+}
+
+func (a forwardedAgent) Add(key agent.AddedKey) error {
+	return a.do(func(a agent.ExtendedAgent) error { return a.Add(key) })
+}
+
+func (a forwardedAgent) Remove(key ssh.PublicKey) error {
+	return a.do(func(a agent.ExtendedAgent) error { return a.Remove(key) })
+}
+
+func (a forwardedAgent) RemoveAll() error {
+	return a.do(func(a agent.ExtendedAgent) error { return a.RemoveAll() })
+}
+
+func (a forwardedAgent) Lock(passphrase []byte) error {
+	return a.do(func(a agent.ExtendedAgent) error { return a.Lock(passphrase) })
+}
+
+func (a forwardedAgent) Unlock(passphrase []byte) error {
+	return a.do(func(a agent.ExtendedAgent) error { return a.Unlock(passphrase) })
+}
+
+func (a forwardedAgent) Signers() (signers []ssh.Signer, err error) {
+	return a.keyring.signers(context.Background())
+}
+
+func (a forwardedAgent) Extension(extensionType string, contents []byte) (res []byte, err error) {
+	err = a.do(func(a agent.ExtendedAgent) error {
+		res, err = a.Extension(extensionType, contents)
+		return err
+	})
+	return res, err
+}
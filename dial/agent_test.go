@@ -0,0 +1,381 @@
+package dial
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveAgent serves an in-memory agent with keys on the unix socket. stop closes the listener and all connections.
+// keys are private keys or agent.AddedKey.
+func serveAgent(t *testing.T, path string, keys ...any) (stop func()) {
+	t.Helper()
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		added, ok := key.(agent.AddedKey)
+		if !ok {
+			added = agent.AddedKey{PrivateKey: key}
+		}
+		if err := keyring.Add(added); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+		wg    sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			_ = l.Close()
+			mu.Lock()
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+			mu.Unlock()
+			wg.Wait()
+		})
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func TestAgentKeyringReconnect(t *testing.T) {
+	key, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	stop := serveAgent(t, path, key)
+
+	keyring := newAgentKeyring(path)
+	defer func() { _ = keyring.Close() }()
+	signers, err := keyring.signers(context.Background())
+	if err != nil || len(signers) != 1 {
+		t.Fatalf("signers() = %v, %v", signers, err)
+	}
+	signer := signers[0]
+	sign := func() {
+		t.Helper()
+		data := []byte("data")
+		sig, err := signer.Sign(rand.Reader, data)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		if err = signer.PublicKey().Verify(data, sig); err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+	}
+	sign()
+
+	// agent restarts with the same key
+	stop()
+	_ = os.Remove(path)
+	serveAgent(t, path, key)
+	sign()
+
+	_ = keyring.Close()
+	if _, err = signer.Sign(rand.Reader, []byte("data")); !errors.Is(err, errAgentClosed) {
+		t.Errorf("Sign() after Close error = %v, want %v", err, errAgentClosed)
+	}
+}
+
+func TestAgentKeyringTimeout(t *testing.T) {
+	// the agent reads requests, but never answers
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	keyring := newAgentKeyring(path)
+	defer func() { _ = keyring.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = keyring.signers(ctx); err == nil {
+		t.Fatalf("signers() from hung agent: expected error")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("signers() took %v, want ctx deadline", took)
+	}
+	// the connection with unread response is dropped
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+	if keyring.conn != nil {
+		t.Errorf("timed out connection is kept")
+	}
+}
+
+func TestDialContextAgent(t *testing.T) {
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		AuthorizedKeys: map[string][]ssh.PublicKey{"key_user": {userPub}},
+		MaxAuthTries:   1,
+	})
+	srv.SetupHome(t, nil)
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "agent.sock")
+	serveAgent(t, sock, otherKey, userKey)
+	pubFile := filepath.Join(dir, "key.pub")
+	if err := os.WriteFile(pubFile, ssh.MarshalAuthorizedKey(userPub), 0600); err != nil {
+		t.Fatal(err)
+	}
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+	agentParam := "IdentityAgent=" + url.QueryEscape(sock)
+
+	tests := []struct {
+		name    string
+		addr    string
+		env     string
+		wantErr bool
+	}{
+		{
+			// MaxAuthTries=1, so the other key is not offered
+			name: "identity agent filtered by public key",
+			addr: fmt.Sprintf("key_user@%s/%s?%s&IdentityFile=%s", srv.Addr, echo, agentParam, url.QueryEscape(pubFile)),
+		},
+		{
+			name: "SSH_AUTH_SOCK",
+			addr: fmt.Sprintf("key_user@%s/%s?IdentityFile=%s", srv.Addr, echo, url.QueryEscape(pubFile)),
+			env:  sock,
+		},
+		{
+			name:    "not filtered",
+			addr:    fmt.Sprintf("key_user@%s/%s?%s&AgentKeys=first", srv.Addr, echo, agentParam),
+			wantErr: true,
+		},
+		{
+			name:    "none",
+			addr:    fmt.Sprintf("key_user@%s/%s?IdentityAgent=none&IdentityFile=%s", srv.Addr, echo, url.QueryEscape(pubFile)),
+			env:     sock,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SSH_AUTH_SOCK", tt.env)
+			var info HandshakeInfo
+			ctx := WithTrace(testCtx(t), &Trace{
+				HandshakeDone: func(i HandshakeInfo, err error) {
+					info = i
+				},
+			})
+			conn, err := NewDialer().DialContext(ctx, tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DialContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if info.KeySource != "agent" {
+				t.Errorf("KeySource = %q, want agent", info.KeySource)
+			}
+			assertEcho(t, conn)
+			if err = conn.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDialContextAgentCertificate(t *testing.T) {
+	caKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             userPub,
+		CertType:        ssh.UserCert,
+		KeyId:           "key_user",
+		ValidPrincipals: []string{"key_user"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err = cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	// the certificate is authorized, not its key, so only the certificate signature is accepted
+	srv := dialtest.Start(t, dialtest.Config{
+		AuthorizedKeys: map[string][]ssh.PublicKey{"key_user": {cert}},
+	})
+	srv.SetupHome(t, nil)
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	serveAgent(t, sock, agent.AddedKey{PrivateKey: userKey, Certificate: cert})
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+
+	conn, err := NewDialer().DialContext(testCtx(t), fmt.Sprintf("key_user@%s/%s?IdentityAgent=%s", srv.Addr, echo, url.QueryEscape(sock)))
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	assertEcho(t, conn)
+	_ = conn.Close()
+}
+
+func TestNewSessionForwardAgent(t *testing.T) {
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		AuthorizedKeys: map[string][]ssh.PublicKey{"key_user": {userPub}},
+		ExecAgent: func(cmd string, a agent.ExtendedAgent, stdin io.Reader, stdout, stderr io.Writer) int {
+			if a == nil {
+				_, _ = fmt.Fprint(stdout, "no agent")
+				return 1
+			}
+			keys, err := a.List()
+			if err != nil {
+				_, _ = fmt.Fprint(stderr, err)
+				return 2
+			}
+			for _, key := range keys {
+				_, _ = fmt.Fprint(stdout, ssh.FingerprintSHA256(key))
+			}
+			return 0
+		},
+	})
+	srv.SetupHome(t, nil)
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	serveAgent(t, sock, userKey)
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	tests := []struct {
+		name    string
+		params  string
+		want    string
+		wantErr bool
+	}{
+		{name: "yes", params: "?ForwardAgent=yes", want: ssh.FingerprintSHA256(userPub)},
+		{name: "default", want: "no agent", wantErr: true},
+		{name: "no", params: "?ForwardAgent=no", want: "no agent", wantErr: true},
+	}
+	d := NewDialer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the second session reuses the pooled client
+			for range 2 {
+				session, err := d.NewSession(testCtx(t), fmt.Sprintf("key_user@%s%s", srv.Addr, tt.params))
+				if err != nil {
+					t.Fatalf("NewSession() error = %v", err)
+				}
+				out, err := session.Output("ssh-add -l")
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Output() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if string(out) != tt.want {
+					t.Errorf("Output() = %q, want %q", out, tt.want)
+				}
+				if err = session.Close(); err != nil {
+					t.Errorf("Close() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSessionForwardAgentDenied(t *testing.T) {
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+		Exec: func(cmd string, stdin io.Reader, stdout, stderr io.Writer) int {
+			return 0
+		},
+	})
+	srv.SetupHome(t, nil)
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	serveAgent(t, sock)
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+	d := NewDialer()
+	// the tunnel holds the pooled client
+	conn, err := d.DialContext(testCtx(t), fmt.Sprintf("user:pass@%s/%s?ForwardAgent=yes", srv.Addr, echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = d.NewSession(testCtx(t), fmt.Sprintf("user:pass@%s?ForwardAgent=yes", srv.Addr))
+	if !errors.Is(err, errAgentForwardingDenied) {
+		t.Fatalf("NewSession() error = %v, want %v", err, errAgentForwardingDenied)
+	}
+	// the client is not evicted on refusal
+	assertEcho(t, conn)
+	if n := srv.Handshakes(); n != 1 {
+		t.Errorf("Handshakes() = %d, want 1", n)
+	}
+}
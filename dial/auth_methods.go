@@ -4,54 +4,200 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
 )
 
-func makeSshAuth(ctx context.Context, home string, config Config) ([]ssh.AuthMethod, func(), error) {
-	auth := appendPasswordAuth(nil, config.Password)
-	auth, dones, errs := appendPublicKeysAuth(ctx, auth, nil, nil, home)
+// makeSshAuth builds auth methods. home may be empty, then ~/.ssh keys are not read.
+func makeSshAuth(ctx context.Context, home string, config Config, secrets authSecrets, dialerKeys []dialerKey, keyring *agentKeyring, res *authResult) ([]ssh.AuthMethod, error) {
+	order, orderErr := authOrder(config.Params)
+	agentKeys, agentKeysErr := parseAgentKeys(config.Params)
+	errs := []error{orderErr, agentKeysErr}
 
-	return auth,
-		func() {
-			for _, d := range dones {
-				d()
-			}
-		},
-		errors.Join(errs...)
+	methods := make(map[string][]ssh.AuthMethod, len(order))
+	methods["password"] = appendPasswordAuth(nil, config.Password, res)
+	methods["password"] = appendPasswordProviderAuth(ctx, methods["password"], secrets.password, res)
+	methods["keyboard-interactive"] = appendKeyboardInteractiveAuth(ctx, nil, secrets.challenge, res)
+	if slices.Contains(order, "publickey") {
+		// don't read keys, if publickey is not preferred
+		if agentKeys == agentKeysNo {
+			keyring = nil
+		}
+		keys := keyReader{ctx: ctx, passphrase: secrets.passphrase}
+		methods["publickey"], errs = appendPublicKeysAuth(ctx, nil, errs, home, config.Params["IdentityFile"], dialerKeys, agentKeys, keyring, keys, res)
+	}
+
+	var auth []ssh.AuthMethod
+	for _, method := range order {
+		auth = append(auth, methods[method]...)
+	}
+	return auth, errors.Join(errs...)
+}
+
+// defaultAuthOrder is used without PreferredAuthentications
+var defaultAuthOrder = []string{"password", "keyboard-interactive", "publickey"}
+
+// authOrder parses PreferredAuthentications: comma separated methods, not listed methods are not used.
+func authOrder(params map[string][]string) ([]string, error) {
+	vals := params["PreferredAuthentications"]
+	switch len(vals) {
+	case 0:
+		return defaultAuthOrder, nil
+	case 1:
+	default:
+		return defaultAuthOrder, errors.New("multiple values for PreferredAuthentications")
+	}
+	var order []string
+	for _, method := range strings.Split(vals[0], ",") {
+		method = strings.TrimSpace(method)
+		if !slices.Contains(defaultAuthOrder, method) {
+			return defaultAuthOrder, fmt.Errorf("invalid value for PreferredAuthentications: unknown method %q", method)
+		}
+		if !slices.Contains(order, method) {
+			order = append(order, method)
+		}
+	}
+	return order, nil
 }
 
-func appendPasswordAuth(auth []ssh.AuthMethod, password *string) []ssh.AuthMethod {
+// AgentKeys param values
+const (
+	agentKeysFirst = "first"
+	agentKeysLast  = "last"
+	agentKeysNo    = "no"
+)
+
+func parseAgentKeys(params map[string][]string) (string, error) {
+	vals := params["AgentKeys"]
+	switch len(vals) {
+	case 0:
+		return agentKeysLast, nil
+	case 1:
+	default:
+		return agentKeysLast, errors.New("multiple values for AgentKeys")
+	}
+	switch vals[0] {
+	case agentKeysFirst, agentKeysLast, agentKeysNo:
+		return vals[0], nil
+	}
+	return agentKeysLast, fmt.Errorf("invalid value for AgentKeys: %q, expected first, last or no", vals[0])
+}
+
+func appendPasswordAuth(auth []ssh.AuthMethod, password *string, res *authResult) []ssh.AuthMethod {
 	if password == nil {
 		return auth
 	}
-	return append(auth, ssh.Password(*password))
+	return append(auth, ssh.PasswordCallback(func() (string, error) {
+		res.set("password", nil)
+		return *password, nil
+	}))
 }
 
-func appendPublicKeysAuth(ctx context.Context, auth []ssh.AuthMethod, done []func(), otherErrs []error, home string) ([]ssh.AuthMethod, []func(), []error) {
-	signers, errs := appendPrivateKeySigners(nil, nil, home)
-	signers, done, errs = appendAgentSigners(ctx, signers, done, errs)
-	if len(signers) > 0 {
-		auth = append(auth, ssh.PublicKeys(signers...))
+// appendPasswordProviderAuth resolves the password, when the server asks for it
+func appendPasswordProviderAuth(ctx context.Context, auth []ssh.AuthMethod, password SecretProvider, res *authResult) []ssh.AuthMethod {
+	if password == nil {
+		return auth
 	}
+	return append(auth, ssh.PasswordCallback(func() (string, error) {
+		res.set("password", nil)
+		secret, err := password.Secret(ctx)
+		if err != nil {
+			return "", fmt.Errorf("password: %w", err)
+		}
+		return secret, nil
+	}))
+}
+
+// identity is a key with its source: a file path or "agent"
+type identity struct {
+	signer ssh.Signer
+	source string
+}
+
+// appendPublicKeysAuth offers keys one by one with a single callback, so the server counts one attempt per key.
+// Keys are deduplicated, Dialer keys go before files, agent keys are offered first or last.
+// Agent keys are listed, only if the server accepts publickey. With IdentityFile, only agent keys of these files are offered.
+func appendPublicKeysAuth(ctx context.Context, auth []ssh.AuthMethod, otherErrs []error, home string, identityFiles []string, dialerKeys []dialerKey, agentKeys string, keyring *agentKeyring, keys keyReader, res *authResult) ([]ssh.AuthMethod, []error) {
+	var wanted []ssh.PublicKey
+	files, errs := appendDialerSigners(ctx, nil, nil, dialerKeys, keys)
+	switch {
+	case len(identityFiles) > 0:
+		files, wanted, errs = appendIdentityFileSigners(files, nil, errs, home, identityFiles, keys)
+	case home != "":
+		files, errs = appendPrivateKeySigners(files, errs, home, keys)
+	}
+	if len(files) == 0 && keyring == nil {
+		return auth, appendPublicKeysErrs(otherErrs, errs)
+	}
+
+	auth = append(auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		var agentIds []identity
+		if keyring != nil {
+			agentIds = agentIdentities(ctx, keyring, identityFiles, wanted)
+		}
+		var ids []identity
+		if agentKeys == agentKeysFirst {
+			ids = append(agentIds, files...)
+		} else {
+			ids = append(slices.Clone(files), agentIds...)
+		}
+		return res.wrapSigners(dedupIdentities(ids)), nil
+	}))
+	return auth, appendPublicKeysErrs(otherErrs, errs)
+}
+
+func appendPublicKeysErrs(otherErrs []error, errs []error) []error {
 	if len(errs) > 0 {
 		otherErrs = append(otherErrs, fmt.Errorf("publickey: %w", errors.Join(errs...)))
 	}
-	return auth, done, otherErrs
+	return otherErrs
 }
 
-func appendPrivateKeySigners(signers []ssh.Signer, errs []error, home string) ([]ssh.Signer, []error) {
+// agentIdentities lists agent keys. Agent errors are logged, so file keys can still be used.
+func agentIdentities(ctx context.Context, keyring *agentKeyring, identityFiles []string, wanted []ssh.PublicKey) []identity {
+	signers, err := keyring.signers(ctx)
+	if err != nil {
+		logger().Warn("mytunnel/dial: cannot list ssh agent keys", "err", err)
+		return nil
+	}
+	var ids []identity
+	for _, signer := range signers {
+		if len(identityFiles) > 0 && !slices.ContainsFunc(wanted, func(pub ssh.PublicKey) bool {
+			return string(pub.Marshal()) == string(signer.PublicKey().Marshal())
+		}) {
+			continue
+		}
+		ids = append(ids, identity{signer: signer, source: "agent"})
+	}
+	return ids
+}
+
+// dedupIdentities keeps the first of the same keys, e.g. a file key, which is also added to the agent
+func dedupIdentities(ids []identity) []identity {
+	seen := make(map[string]bool, len(ids))
+	return slices.DeleteFunc(ids, func(id identity) bool {
+		key := string(id.signer.PublicKey().Marshal())
+		if seen[key] {
+			return true
+		}
+		seen[key] = true
+		return false
+	})
+}
+
+func appendPrivateKeySigners(ids []identity, errs []error, home string, keys keyReader) ([]identity, []error) {
 	sshDirPath := filepath.Join(home, ".ssh")
 	sshDir, err := os.Open(sshDirPath)
 
 	if err != nil {
 		errs = append(errs, err)
-		return signers, errs
+		return ids, errs
 	}
 
 	sshFiles, err := sshDir.Readdir(-1)
@@ -59,7 +205,7 @@ func appendPrivateKeySigners(signers []ssh.Signer, errs []error, home string) ([
 
 	if err != nil {
 		errs = append(errs, err)
-		return signers, errs
+		return ids, errs
 	}
 
 	for _, file := range sshFiles {
@@ -72,63 +218,162 @@ func appendPrivateKeySigners(signers []ssh.Signer, errs []error, home string) ([
 		if strings.HasSuffix(file.Name(), ".pub") {
 			continue
 		}
-		filePath := filepath.Join(sshDirPath, file.Name())
-		buf, err := os.ReadFile(filePath)
+		path := filepath.Join(sshDirPath, file.Name())
+		pk, err := keys.read(path, file.Name())
 		if err != nil {
-			errs = append(errs, fmt.Errorf("cannot read file %s: %w", file.Name(), err))
+			errs = append(errs, err)
 			continue
 		}
-		pk, err := ssh.ParsePrivateKey(buf)
+		ids = append(ids, identity{signer: pk, source: path})
+	}
+
+	return ids, errs
+}
+
+// appendIdentityFileSigners reads keys from IdentityFile params instead of ~/.ssh/id_*.
+// Public keys of the files are returned to filter agent keys. The private key may be only in the agent,
+// then the public one is read from the file itself or from the .pub file.
+func appendIdentityFileSigners(ids []identity, wanted []ssh.PublicKey, errs []error, home string, files []string, keys keyReader) ([]identity, []ssh.PublicKey, []error) {
+	for _, file := range files {
+		path := expandHome(file, home)
+		pk, err := keys.read(path, file)
+		if err == nil {
+			ids = append(ids, identity{signer: pk, source: path})
+			wanted = append(wanted, pk.PublicKey())
+			continue
+		}
+		if pub := readPublicKey(path, err); pub != nil {
+			wanted = append(wanted, pub)
+			continue
+		}
+		errs = append(errs, err)
+	}
+	return ids, wanted, errs
+}
+
+// readPublicKey gets the public key of an encrypted private key, a public key file or its .pub file
+func readPublicKey(path string, readErr error) ssh.PublicKey {
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(readErr, &missingErr) && missingErr.PublicKey != nil {
+		return missingErr.PublicKey
+	}
+	for _, p := range []string{path, path + ".pub"} {
+		buf, err := os.ReadFile(p)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("cannot parse private key from file %s: %w", file.Name(), err))
 			continue
 		}
-		signers = append(signers, pk)
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+		if err == nil {
+			return pub
+		}
 	}
+	return nil
+}
 
-	return signers, errs
+// keyReader reads private keys, encrypted ones are decrypted with the passphrase
+type keyReader struct {
+	ctx        context.Context
+	passphrase SecretProvider
 }
 
-func appendAgentSigners(ctx context.Context, signers []ssh.Signer, done []func(), errs []error) ([]ssh.Signer, []func(), []error) {
-	sshAuthSock := os.Getenv("SSH_AUTH_SOCK")
-	if sshAuthSock == "" {
-		return signers, done, errs
-	}
-	var d net.Dialer
-	conn, err := d.DialContext(ctx, "unix", sshAuthSock)
+func (r keyReader) read(path, name string) (ssh.Signer, error) {
+	buf, err := os.ReadFile(path)
 	if err != nil {
-		errs = append(errs, err)
-		return signers, done, errs
+		return nil, fmt.Errorf("cannot read file %s: %w", name, err)
 	}
+	return r.parse(buf, "file "+name)
+}
 
-	type signersErr struct {
-		signers []ssh.Signer
-		err     error
-	}
-	ch := make(chan signersErr)
-	go func() {
-		s, e := agent.NewClient(conn).Signers()
-		select {
-		case ch <- signersErr{signers: s, err: e}:
-		case <-ctx.Done():
+// parse parses a PEM private key, name describes its source in errors
+func (r keyReader) parse(buf []byte, name string) (ssh.Signer, error) {
+	pk, err := ssh.ParsePrivateKey(buf)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) && r.passphrase != nil {
+		var passphrase string
+		passphrase, err = r.passphrase.Secret(r.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get passphrase for %s: %w", name, err)
 		}
-	}()
+		pk, err = ssh.ParsePrivateKeyWithPassphrase(buf, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key from %s: %w", name, err)
+	}
+	return pk, nil
+}
 
-	select {
-	case <-ctx.Done():
+// authResult records the last used auth method. After a successful handshake, it is the accepted one.
+type authResult struct {
+	mu     sync.Mutex
+	method string
+	key    ssh.PublicKey
+	source string
+}
 
-		_ = conn.Close()
-		errs = append(errs, fmt.Errorf("ssh agent ctx: %w", ctx.Err()))
+func (r *authResult) set(method string, key ssh.PublicKey) {
+	r.setKey(method, key, "")
+}
 
-	case res := <-ch:
+func (r *authResult) setKey(method string, key ssh.PublicKey, source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.method, r.key, r.source = method, key, source
+}
 
-		signers = append(signers, res.signers...)
-		done = append(done, func() { _ = conn.Close() })
-		if res.err != nil {
-			errs = append(errs, res.err)
-		}
+func (r *authResult) info() HandshakeInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return HandshakeInfo{AuthMethod: r.method, PublicKey: r.key, KeySource: r.source}
+}
 
+// wrapSigners records a key, when the server asks to sign with it.
+// Algorithm signers stay algorithm signers, otherwise ssh would fall back to ssh-rsa for rsa keys.
+func (r *authResult) wrapSigners(ids []identity) []ssh.Signer {
+	wrapped := make([]ssh.Signer, 0, len(ids))
+	for _, id := range ids {
+		signer := id.signer
+		rs := recordingSigner{Signer: signer, res: r, source: id.source}
+		as, ok := signer.(ssh.AlgorithmSigner)
+		if !ok {
+			wrapped = append(wrapped, rs)
+			continue
+		}
+		ras := recordingAlgorithmSigner{recordingSigner: rs, as: as}
+		if ms, ok := signer.(ssh.MultiAlgorithmSigner); ok {
+			wrapped = append(wrapped, recordingMultiAlgorithmSigner{recordingAlgorithmSigner: ras, ms: ms})
+		} else {
+			wrapped = append(wrapped, ras)
+		}
 	}
+	return wrapped
+}
+
+type recordingSigner struct {
+	ssh.Signer
+	res    *authResult
+	source string
+}
+
+func (s recordingSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.res.setKey("publickey", s.PublicKey(), s.source)
+	return s.Signer.Sign(rand, data)
+}
+
+type recordingAlgorithmSigner struct {
+	recordingSigner
+	as ssh.AlgorithmSigner
+}
+
+func (s recordingAlgorithmSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.res.setKey("publickey", s.PublicKey(), s.source)
+	return s.as.SignWithAlgorithm(rand, data, algorithm)
+}
+
+type recordingMultiAlgorithmSigner struct {
+	recordingAlgorithmSigner
+	ms ssh.MultiAlgorithmSigner
+}
 
-	return signers, done, errs
+func (s recordingMultiAlgorithmSigner) Algorithms() []string {
+	return s.ms.Algorithms()
 }
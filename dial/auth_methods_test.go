@@ -0,0 +1,91 @@
+package dial
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/url"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_authOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  url.Values
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "default",
+			want: defaultAuthOrder,
+		},
+		{
+			name:   "preferred",
+			params: url.Values{"PreferredAuthentications": {"publickey, keyboard-interactive,publickey"}},
+			want:   []string{"publickey", "keyboard-interactive"},
+		},
+		{
+			name:    "unknown",
+			params:  url.Values{"PreferredAuthentications": {"publickey,gssapi-with-mic"}},
+			want:    defaultAuthOrder,
+			wantErr: true,
+		},
+		{
+			name:    "multiple",
+			params:  url.Values{"PreferredAuthentications": {"publickey", "password"}},
+			want:    defaultAuthOrder,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := authOrder(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("authOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("authOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseAgentKeys(t *testing.T) {
+	for val, want := range map[string]string{"": agentKeysLast, "first": agentKeysFirst, "no": agentKeysNo} {
+		params := url.Values{}
+		if val != "" {
+			params.Set("AgentKeys", val)
+		}
+		if got, err := parseAgentKeys(params); got != want || err != nil {
+			t.Errorf("parseAgentKeys(%q) = %v, %v, want %v", val, got, err, want)
+		}
+	}
+	if _, err := parseAgentKeys(url.Values{"AgentKeys": {"yes"}}); err == nil {
+		t.Errorf("parseAgentKeys(yes) expected error")
+	}
+}
+
+func Test_dedupIdentities(t *testing.T) {
+	signer := func() ssh.Signer {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	a, b := signer(), signer()
+	ids := dedupIdentities([]identity{{a, "agent"}, {b, "b"}, {a, "a"}})
+	var sources []string
+	for _, id := range ids {
+		sources = append(sources, id.source)
+	}
+	if !slices.Equal(sources, []string{"agent", "b"}) {
+		t.Errorf("dedupIdentities() = %v", sources)
+	}
+}
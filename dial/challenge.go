@@ -0,0 +1,150 @@
+package dial
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ChallengeResponder answers keyboard-interactive challenges of the server.
+// It must return an answer for each question.
+type ChallengeResponder interface {
+	Respond(ctx context.Context, name, instruction string, questions []string, echos []bool) ([]string, error)
+}
+
+// ChallengeFunc is an adapter to use ordinary functions as ChallengeResponder.
+type ChallengeFunc func(ctx context.Context, name, instruction string, questions []string, echos []bool) ([]string, error)
+
+func (f ChallengeFunc) Respond(ctx context.Context, name, instruction string, questions []string, echos []bool) ([]string, error) {
+	return f(ctx, name, instruction, questions, echos)
+}
+
+// PromptAnswer answers questions, which match Prompt.
+type PromptAnswer struct {
+	Prompt *regexp.Regexp
+	Answer SecretProvider
+}
+
+// PromptResponder answers each question with the first matching PromptAnswer.
+// A question without a match fails the challenge.
+type PromptResponder []PromptAnswer
+
+func (r PromptResponder) Respond(ctx context.Context, name, instruction string, questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		idx := -1
+		for j, a := range r {
+			if a.Prompt.MatchString(question) {
+				idx = j
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("no answer for prompt %q", question)
+		}
+		answer, err := r[idx].Answer.Secret(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("prompt %q: %w", question, err)
+		}
+		answers[i] = answer
+	}
+	return answers, nil
+}
+
+// WithChallengeResponder sets the responder for keyboard-interactive auth.
+// By default, password and TOTP code prompts are answered, if they are configured.
+func WithChallengeResponder(r ChallengeResponder) Option {
+	return func(d *Dialer) {
+		d.challengeResponder = r
+	}
+}
+
+var (
+	passwordPrompt = regexp.MustCompile(`(?i)password`)
+	otpPrompt      = regexp.MustCompile(`(?i)verification|one-time|otp|code|token`)
+)
+
+// defaultResponder answers password and TOTP prompts. nil is returned, if nothing is configured.
+// OTP prompts go first, they often contain "password" too, e.g. "One-time password (OATH) for `user':".
+func defaultResponder(password, totp SecretProvider) ChallengeResponder {
+	var r PromptResponder
+	if totp != nil {
+		r = append(r, PromptAnswer{Prompt: otpPrompt, Answer: TOTP(totp)})
+	}
+	if password != nil {
+		r = append(r, PromptAnswer{Prompt: passwordPrompt, Answer: password})
+	}
+	if len(r) == 0 {
+		return nil
+	}
+	return r
+}
+
+func appendKeyboardInteractiveAuth(ctx context.Context, auth []ssh.AuthMethod, responder ChallengeResponder, res *authResult) []ssh.AuthMethod {
+	if responder == nil {
+		return auth
+	}
+	return append(auth, ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		res.set("keyboard-interactive", nil)
+		if len(questions) == 0 {
+			// info message, nothing to answer
+			return nil, nil
+		}
+		answers, err := responder.Respond(ctx, name, instruction, questions, echos)
+		if err != nil {
+			return nil, fmt.Errorf("keyboard-interactive: %w", err)
+		}
+		return answers, nil
+	}))
+}
+
+// TOTP returns the current RFC 6238 code (HMAC-SHA1, 6 digits, 30s step) for the base32 secret.
+func TOTP(secret SecretProvider) SecretProvider {
+	return totp{secret: secret, now: time.Now}
+}
+
+type totp struct {
+	secret SecretProvider
+	now    func() time.Time
+}
+
+func (t totp) Secret(ctx context.Context) (string, error) {
+	secret, err := t.secret.Secret(ctx)
+	if err != nil {
+		return "", err
+	}
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, t.now()), nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	secret = strings.TrimRight(secret, "=")
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return key, nil
+}
+
+func totpCode(key []byte, t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000)
+}
@@ -0,0 +1,86 @@
+package dial
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_totpCode(t *testing.T) {
+	// RFC 6238 test vectors for SHA1, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("totpCode(%d) = %v, want %v", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	secret := SecretFunc(func(ctx context.Context) (string, error) {
+		return "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", nil
+	})
+	code, err := totp{secret: secret, now: func() time.Time { return time.Unix(59, 0) }}.Secret(context.Background())
+	if err != nil || code != "287082" {
+		t.Errorf("TOTP() = %v, %v, want 287082", code, err)
+	}
+
+	invalid := SecretFunc(func(ctx context.Context) (string, error) {
+		return "not base32!", nil
+	})
+	if _, err = TOTP(invalid).Secret(context.Background()); err == nil {
+		t.Errorf("TOTP() expected error for invalid secret")
+	}
+}
+
+func TestPromptResponder(t *testing.T) {
+	static := func(val string) SecretProvider {
+		return SecretFunc(func(ctx context.Context) (string, error) { return val, nil })
+	}
+	r := PromptResponder{
+		{Prompt: regexp.MustCompile(`(?i)password`), Answer: static("pass")},
+		{Prompt: regexp.MustCompile(`(?i)code`), Answer: static("123456")},
+	}
+
+	got, err := r.Respond(context.Background(), "", "", []string{"Password: ", "Verification code: "}, []bool{false, true})
+	if err != nil || !slices.Equal(got, []string{"pass", "123456"}) {
+		t.Errorf("Respond() = %v, %v", got, err)
+	}
+
+	_, err = r.Respond(context.Background(), "", "", []string{"Favorite color: "}, []bool{true})
+	if err == nil {
+		t.Errorf("Respond() expected error for unknown prompt")
+	}
+}
+
+func Test_defaultResponder(t *testing.T) {
+	static := func(val string) SecretProvider {
+		return SecretFunc(func(ctx context.Context) (string, error) { return val, nil })
+	}
+	r := defaultResponder(static("pass"), static("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"))
+	questions := []string{"Password: ", "One-time password (OATH) for `user':", "OTP password:", "Verification code: "}
+	got, err := r.Respond(context.Background(), "", "", questions, make([]bool, len(questions)))
+	if err != nil {
+		t.Fatalf("Respond() error = %v", err)
+	}
+	if got[0] != "pass" {
+		t.Errorf("Respond(%q) = %q, want the password", questions[0], got[0])
+	}
+	for i, question := range questions[1:] {
+		if answer := got[i+1]; answer == "pass" || len(answer) != 6 {
+			t.Errorf("Respond(%q) = %q, want a TOTP code", question, answer)
+		}
+	}
+}
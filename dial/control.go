@@ -0,0 +1,142 @@
+package dial
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// errNoControlMaster means, that nobody listens ControlPath, so a normal ssh client is used
+var errNoControlMaster = errors.New("no control master")
+
+// controlPath returns the expanded ControlPath param. Empty path means, that control master is not used.
+// Supported tokens: %h and %n host, %p port, %r remote user, %u local user, %i its uid, %d its home,
+// %l local host name, %L its first component, %C hash of %l%h%p%r, like in OpenSSH, and %%.
+func (c Config) controlPath() (string, error) {
+	vals := c.Params["ControlPath"]
+	switch len(vals) {
+	case 0:
+		return "", nil
+	case 1:
+	default:
+		return "", errors.New("multiple values for ControlPath")
+	}
+	if vals[0] == "none" {
+		return "", nil
+	}
+	path, err := c.expandTokens(vals[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid value for ControlPath: %w", err)
+	}
+	home, _ := os.UserHomeDir()
+	return expandHome(path, home), nil
+}
+
+func (c Config) expandTokens(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '%')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		if i+1 == len(s) {
+			return "", errors.New("trailing %")
+		}
+		token := s[i+1]
+		val, err := c.token(token)
+		if err != nil {
+			return "", fmt.Errorf("%%%c: %w", token, err)
+		}
+		b.WriteString(val)
+		s = s[i+2:]
+	}
+}
+
+func (c Config) token(token byte) (string, error) {
+	switch token {
+	case '%':
+		return "%", nil
+	case 'h', 'n':
+		// no ssh config, so the host is never renamed
+		return c.Host, nil
+	case 'p':
+		port := c.Port
+		if port == 0 {
+			port = DefaultPort
+		}
+		return strconv.Itoa(port), nil
+	case 'r':
+		return c.Username, nil
+	case 'u', 'i', 'd':
+		u, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		switch token {
+		case 'i':
+			return u.Uid, nil
+		case 'd':
+			return u.HomeDir, nil
+		}
+		return u.Username, nil
+	case 'l', 'L':
+		host, err := os.Hostname()
+		if err != nil {
+			return "", err
+		}
+		if token == 'L' {
+			host, _, _ = strings.Cut(host, ".")
+		}
+		return host, nil
+	case 'C':
+		var b strings.Builder
+		for _, t := range []byte("lhpr") {
+			val, err := c.token(t)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(val)
+		}
+		sum := sha1.Sum([]byte(b.String()))
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return "", errors.New("unknown token")
+}
+
+// dialControlMaster forwards the target through an OpenSSH ControlMaster, if ControlPath is set and the master listens.
+// nil conn without error means, that a normal ssh client must be used.
+func (d *Dialer) dialControlMaster(ctx context.Context, config Config) (net.Conn, error) {
+	if d.backend != nil {
+		return nil, nil
+	}
+	path, err := config.controlPath()
+	if err != nil {
+		logger().Warn("mytunnel/dial: invalid ControlPath, ignore", "err", err)
+		return nil, nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	conn, err := dialControlMaster(ctx, path, config.Net, config.Addr)
+	if errors.Is(err, errNoControlMaster) {
+		logger().Debug("mytunnel/dial: control master is not used", "path", path, "err", err)
+		return nil, nil
+	}
+	if trace := ContextTrace(ctx); trace != nil && trace.ChannelOpenDone != nil {
+		trace.ChannelOpenDone(config.Net, config.Addr, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("control master %s: %w", path, err)
+	}
+	logger().Debug("mytunnel/dial: forwarded by control master", "config", config, "path", path)
+	return conn, nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package dial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// dialControlMaster falls back to a normal ssh client, fd passing of the mux protocol needs unix sockets
+func dialControlMaster(ctx context.Context, path string, network, addr string) (net.Conn, error) {
+	return nil, fmt.Errorf("%w: %w", errNoControlMaster, errors.ErrUnsupported)
+}
@@ -0,0 +1,78 @@
+package dial
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"os/user"
+	"testing"
+)
+
+func TestConfig_controlPath(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha1.Sum([]byte(hostname + "example.com" + "22" + "user"))
+
+	tests := []struct {
+		name    string
+		config  Config
+		want    string
+		wantErr bool
+	}{
+		{name: "not set", config: Config{Host: "example.com"}},
+		{name: "none", config: Config{Params: url.Values{"ControlPath": {"none"}}}},
+		{
+			name:   "tokens",
+			config: Config{Username: "user", Host: "example.com", Params: url.Values{"ControlPath": {"/tmp/%r@%h:%p%%"}}},
+			want:   "/tmp/user@example.com:22%",
+		},
+		{
+			name:   "port",
+			config: Config{Host: "example.com", Port: 2222, Params: url.Values{"ControlPath": {"/tmp/%h-%p"}}},
+			want:   "/tmp/example.com-2222",
+		},
+		{
+			name:   "local user",
+			config: Config{Params: url.Values{"ControlPath": {"/tmp/%u-%i-%d"}}},
+			want:   "/tmp/" + u.Username + "-" + u.Uid + "-" + u.HomeDir,
+		},
+		{
+			name:   "hash",
+			config: Config{Username: "user", Host: "example.com", Params: url.Values{"ControlPath": {"/tmp/%C-%n"}}},
+			want:   "/tmp/" + hex.EncodeToString(hash[:]) + "-example.com",
+		},
+		{
+			name:    "unknown token",
+			config:  Config{Params: url.Values{"ControlPath": {"/tmp/%X"}}},
+			wantErr: true,
+		},
+		{
+			name:    "trailing %",
+			config:  Config{Params: url.Values{"ControlPath": {"/tmp/%"}}},
+			wantErr: true,
+		},
+		{
+			name:    "multiple",
+			config:  Config{Params: url.Values{"ControlPath": {"/a", "/b"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.controlPath()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("controlPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("controlPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
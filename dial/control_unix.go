@@ -0,0 +1,226 @@
+//go:build unix
+
+package dial
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// OpenSSH PROTOCOL.mux
+const (
+	muxMsgHello          = 0x00000001
+	muxCNewStdioFwd      = 0x10000008
+	muxSPermissionDenied = 0x80000002
+	muxSFailure          = 0x80000003
+	muxSSessionOpened    = 0x80000006
+
+	muxVersion = 4
+	// muxPortStreamlocal makes the host of a stdio forward a unix socket path
+	muxPortStreamlocal = 0xfffffffe
+	muxMaxPacket       = 256 << 10
+)
+
+// dialControlMaster asks the master to forward one end of a socket pair to the target, like ssh -W does.
+// The mux connection lives as long as the forward.
+func dialControlMaster(ctx context.Context, path string, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	mux, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errNoControlMaster, err)
+	}
+	conn, err := muxStdioForward(ctx, mux.(*net.UnixConn), network, addr)
+	if err != nil {
+		_ = mux.Close()
+		return nil, err
+	}
+	return &controlConn{Conn: conn, mux: mux}, nil
+}
+
+func muxStdioForward(ctx context.Context, mux *net.UnixConn, network, addr string) (net.Conn, error) {
+	host, port, err := muxTarget(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = mux.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = mux.SetDeadline(time.Now()) })
+	defer func() {
+		stop()
+		_ = mux.SetDeadline(time.Time{})
+	}()
+	ctxErr := func(err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	if err = muxWrite(mux, ssh.Marshal(struct{ Type, Version uint32 }{muxMsgHello, muxVersion})); err != nil {
+		return nil, ctxErr(err)
+	}
+	buf, err := muxRead(mux)
+	if err != nil {
+		return nil, ctxErr(err)
+	}
+	var hello struct {
+		Type, Version uint32
+		Extensions    []byte `ssh:"rest"`
+	}
+	if err = ssh.Unmarshal(buf, &hello); err != nil || hello.Type != muxMsgHello {
+		return nil, fmt.Errorf("unexpected control master hello")
+	}
+	if hello.Version != muxVersion {
+		return nil, fmt.Errorf("unsupported control master protocol version %d", hello.Version)
+	}
+
+	const requestID = 1
+	err = muxWrite(mux, ssh.Marshal(struct {
+		Type      uint32
+		RequestID uint32
+		Reserved  string
+		Host      string
+		Port      uint32
+	}{muxCNewStdioFwd, requestID, "", host, port}))
+	if err != nil {
+		return nil, ctxErr(err)
+	}
+	local, err := muxSendSocket(mux)
+	if err != nil {
+		return nil, ctxErr(err)
+	}
+
+	buf, err = muxRead(mux)
+	if err != nil {
+		_ = local.Close()
+		return nil, ctxErr(err)
+	}
+	if err = muxReply(buf, requestID); err != nil {
+		_ = local.Close()
+		return nil, err
+	}
+	return local, nil
+}
+
+func muxTarget(network, addr string) (string, uint32, error) {
+	if network == "unix" {
+		return addr, muxPortStreamlocal, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", port)
+	}
+	return host, uint32(p), nil
+}
+
+// muxSendSocket creates a socket pair and passes one end to the master as stdin and stdout of the forward
+func muxSendSocket(mux *net.UnixConn) (net.Conn, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, os.NewSyscallError("socketpair", err)
+	}
+	defer func() { _ = syscall.Close(fds[1]) }()
+
+	f := os.NewFile(uintptr(fds[0]), "control master forward")
+	local, err := net.FileConn(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	rights := syscall.UnixRights(fds[1])
+	for range 2 {
+		if _, _, err = mux.WriteMsgUnix([]byte{0}, rights, nil); err != nil {
+			_ = local.Close()
+			return nil, err
+		}
+	}
+	return local, nil
+}
+
+func muxReply(buf []byte, requestID uint32) error {
+	if len(buf) < 8 {
+		return errors.New("short control master reply")
+	}
+	if id := binary.BigEndian.Uint32(buf[4:]); id != requestID {
+		return fmt.Errorf("control master reply for request %d, want %d", id, requestID)
+	}
+	switch typ := binary.BigEndian.Uint32(buf); typ {
+	case muxSSessionOpened:
+		return nil
+	case muxSPermissionDenied, muxSFailure:
+		var msg struct {
+			Type, RequestID uint32
+			Reason          string
+		}
+		if err := ssh.Unmarshal(buf, &msg); err != nil {
+			return fmt.Errorf("control master failure: %w", err)
+		}
+		if typ == muxSPermissionDenied {
+			return fmt.Errorf("control master permission denied: %s", msg.Reason)
+		}
+		return fmt.Errorf("control master failure: %s", msg.Reason)
+	default:
+		return fmt.Errorf("unexpected control master reply %#x", typ)
+	}
+}
+
+func muxWrite(w io.Writer, payload []byte) error {
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	_, err := w.Write(append(buf, payload...))
+	return err
+}
+
+func muxRead(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > muxMaxPacket {
+		return nil, fmt.Errorf("control master packet is too big: %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// controlConn is a forward of the control master. The mux connection is closed with it.
+type controlConn struct {
+	net.Conn
+	mux net.Conn
+}
+
+func (c *controlConn) Close() error {
+	err := c.Conn.Close()
+	return errors.Join(err, c.mux.Close())
+}
+
+func (c *controlConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+//go:build unix
+
+package dial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeControlMaster serves stdio forwards of the OpenSSH mux protocol by dialing targets locally
+type fakeControlMaster struct {
+	// deny refuses forwards with the reason, if not empty
+	deny     string
+	forwards atomic.Int64
+}
+
+func (m *fakeControlMaster) listen(t *testing.T, path string) {
+	t.Helper()
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn.(*net.UnixConn))
+		}
+	}()
+}
+
+func (m *fakeControlMaster) serve(mux *net.UnixConn) {
+	defer func() { _ = mux.Close() }()
+	if _, err := muxRead(mux); err != nil {
+		return
+	}
+	if err := muxWrite(mux, ssh.Marshal(struct{ Type, Version uint32 }{muxMsgHello, muxVersion})); err != nil {
+		return
+	}
+	buf, err := muxRead(mux)
+	if err != nil {
+		return
+	}
+	var req struct {
+		Type      uint32
+		RequestID uint32
+		Reserved  string
+		Host      string
+		Port      uint32
+	}
+	if err = ssh.Unmarshal(buf, &req); err != nil || req.Type != muxCNewStdioFwd {
+		return
+	}
+	var fds []int
+	for range 2 {
+		fd, err := receiveFd(mux)
+		if err != nil {
+			return
+		}
+		fds = append(fds, fd)
+	}
+	_ = syscall.Close(fds[1])
+	f := os.NewFile(uintptr(fds[0]), "forward")
+	conn, err := net.FileConn(f)
+	_ = f.Close()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	if m.deny != "" {
+		_ = muxWrite(mux, ssh.Marshal(struct {
+			Type, RequestID uint32
+			Reason          string
+		}{muxSPermissionDenied, req.RequestID, m.deny}))
+		return
+	}
+	var target net.Conn
+	if req.Port == muxPortStreamlocal {
+		target, err = net.Dial("unix", req.Host)
+	} else {
+		target, err = net.Dial("tcp", net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port))))
+	}
+	if err != nil {
+		_ = muxWrite(mux, ssh.Marshal(struct {
+			Type, RequestID uint32
+			Reason          string
+		}{muxSFailure, req.RequestID, err.Error()}))
+		return
+	}
+	defer func() { _ = target.Close() }()
+	m.forwards.Add(1)
+	if err = muxWrite(mux, ssh.Marshal(struct{ Type, RequestID, SessionID uint32 }{muxSSessionOpened, req.RequestID, 1})); err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(target, conn)
+		_ = target.Close()
+	}()
+	_, _ = io.Copy(conn, target)
+}
+
+func receiveFd(conn *net.UnixConn) (int, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return -1, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return -1, fmt.Errorf("no control message: %v", err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return -1, fmt.Errorf("no fd: %v", err)
+	}
+	return fds[0], nil
+}
+
+func TestDialContextControlMaster(t *testing.T) {
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+	})
+	srv.SetupHome(t, nil)
+	dir := t.TempDir()
+	master := &fakeControlMaster{}
+	master.listen(t, filepath.Join(dir, "master.sock"))
+	// ControlPath=%r%%%p.sock
+	master.listen(t, filepath.Join(dir, fmt.Sprintf("user%%%d.sock", srv.Port())))
+	denying := &fakeControlMaster{deny: "forwarding disabled"}
+	denying.listen(t, filepath.Join(dir, "denying.sock"))
+	tcpEcho := listenEcho(t, "tcp", "127.0.0.1:0")
+	unixEcho := listenEcho(t, "unix", filepath.Join(dir, "echo.sock"))
+
+	tests := []struct {
+		name          string
+		addr          string
+		wantForwarded bool
+		wantErr       string
+	}{
+		{
+			name:          "tcp",
+			addr:          fmt.Sprintf("user:pass@%s/%s?ControlPath=%s", srv.Addr, tcpEcho, url.QueryEscape(filepath.Join(dir, "master.sock"))),
+			wantForwarded: true,
+		},
+		{
+			name:          "unix",
+			addr:          fmt.Sprintf("user:pass@%s%s?ControlPath=%s", srv.Addr, unixEcho, url.QueryEscape(filepath.Join(dir, "master.sock"))),
+			wantForwarded: true,
+		},
+		{
+			name:          "tokens",
+			addr:          fmt.Sprintf("user:pass@%s/%s?ControlPath=%s", srv.Addr, tcpEcho, url.QueryEscape(filepath.Join(dir, "%r%%%p.sock"))),
+			wantForwarded: true,
+		},
+		{
+			name: "no master",
+			addr: fmt.Sprintf("user:pass@%s/%s?ControlPath=%s", srv.Addr, tcpEcho, url.QueryEscape(filepath.Join(dir, "missing.sock"))),
+		},
+		{
+			name: "none",
+			addr: fmt.Sprintf("user:pass@%s/%s?ControlPath=none", srv.Addr, tcpEcho),
+		},
+		{
+			name:    "denied",
+			addr:    fmt.Sprintf("user:pass@%s/%s?ControlPath=%s", srv.Addr, tcpEcho, url.QueryEscape(filepath.Join(dir, "denying.sock"))),
+			wantErr: "forwarding disabled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwards, handshakes := master.forwards.Load(), srv.Handshakes()
+			conn, err := NewDialer().DialContext(testCtx(t), tt.addr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DialContext() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DialContext() error = %v", err)
+			}
+			defer func() { _ = conn.Close() }()
+			assertEcho(t, conn)
+			forwarded := master.forwards.Load() > forwards
+			if forwarded != tt.wantForwarded {
+				t.Errorf("forwarded by master = %t, want %t", forwarded, tt.wantForwarded)
+			}
+			if newClient := srv.Handshakes() > handshakes; newClient == forwarded {
+				t.Errorf("new ssh client = %t, forwarded by master = %t", newClient, forwarded)
+			}
+		})
+	}
+}
+
+func TestDialContextControlMasterClosed(t *testing.T) {
+	// a stale socket file without a listener
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = l.Close()
+
+	_, err = dialControlMaster(testCtx(t), path, "tcp", "127.0.0.1:1")
+	if !errors.Is(err, errNoControlMaster) {
+		t.Errorf("dialControlMaster() error = %v, want %v", err, errNoControlMaster)
+	}
+}
+
+func TestDialContextOpenSSHControlMaster(t *testing.T) {
+	sshPath, err := exec.LookPath("ssh")
+	if err != nil {
+		t.Skip("no ssh client:", err)
+	}
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		AuthorizedKeys: map[string][]ssh.PublicKey{"key_user": {userPub}},
+	})
+	home := srv.SetupHome(t, nil)
+	identity := writeKey(t, filepath.Join(home, "id_master"), userKey, "")
+	// unix socket paths are short, t.TempDir may be too long for a hash
+	dir, err := os.MkdirTemp("", "mux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	controlPath := filepath.Join(dir, "%C")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var stderr bytes.Buffer
+	master := exec.CommandContext(ctx, sshPath, "-M", "-N", "-F", "/dev/null",
+		"-o", "ControlPath="+controlPath,
+		"-o", "BatchMode=yes",
+		"-o", "IdentitiesOnly=yes",
+		"-o", "IdentityFile="+identity,
+		"-o", "UserKnownHostsFile="+filepath.Join(home, ".ssh", "known_hosts"),
+		"-o", "StrictHostKeyChecking=yes",
+		"-p", strconv.Itoa(srv.Port()),
+		"key_user@"+srv.Host())
+	master.Stderr = &stderr
+	if err = master.Start(); err != nil {
+		cancel()
+		t.Skip("can't start ssh:", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = master.Wait()
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-exited
+	})
+
+	config := Config{Username: "key_user", Host: srv.Host(), Port: srv.Port()}
+	socket, err := config.expandTokens(controlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err = os.Stat(socket); err == nil {
+			break
+		}
+		select {
+		case <-exited:
+			t.Skipf("ssh master has exited: %s", stderr.String())
+		case <-time.After(10 * time.Millisecond):
+		case <-testCtx(t).Done():
+			t.Skipf("ssh master is not listening %s: %s", socket, stderr.String())
+		}
+	}
+	if srv.Handshakes() != 1 {
+		t.Fatalf("Handshakes() = %d, want 1 of the master", srv.Handshakes())
+	}
+
+	// no identity and no agent, so the tunnel can be established by the master only
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+	conn, err := NewDialer().DialContext(testCtx(t), fmt.Sprintf("key_user@%s/%s?IdentityAgent=none&ControlPath=%s",
+		srv.Addr, echo, url.QueryEscape(controlPath)))
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	assertEcho(t, conn)
+	if srv.Handshakes() != 1 || srv.Channels() != 1 {
+		t.Errorf("Handshakes() = %d, Channels() = %d, want 1, 1", srv.Handshakes(), srv.Channels())
+	}
+}
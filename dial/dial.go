@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/url"
 	"os"
@@ -13,79 +12,168 @@ import (
 	"sync"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	kh "golang.org/x/crypto/ssh/knownhosts"
 )
 
-var clientPool = newClientPool()
+// Dialer dials through ssh. Each Dialer has its own pool of ssh clients.
+// Package level functions use a default Dialer.
+type Dialer struct {
+	pool    *sshClientPool
+	faults  *Faults
+	backend *MemoryBackend
+	clock   Clock
+	// lookupEnv reads MYTUNNEL_* variables, nil disables the env layer
+	lookupEnv func(string) (string, bool)
+
+	passwordProvider   SecretProvider
+	passphraseProvider SecretProvider
+	challengeResponder ChallengeResponder
+	secrets            *secretCache
+	// secretCommands enables *Command params, see WithSecretCommands
+	secretCommands bool
+	// keys are offered before key files, see WithSigners
+	keys []dialerKey
+}
+
+type Option func(d *Dialer)
+
+func NewDialer(opts ...Option) *Dialer {
+	d := &Dialer{
+		pool:      newClientPool(),
+		clock:     systemClock{},
+		lookupEnv: os.LookupEnv,
+		secrets:   newSecretCache(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+var defaultDialer = NewDialer()
+
+// WithLookupEnv sets a source of MYTUNNEL_* variables instead of os.LookupEnv.
+// nil disables the env layer, so only the address is used.
+func WithLookupEnv(lookup func(key string) (string, bool)) Option {
+	return func(d *Dialer) {
+		d.lookupEnv = lookup
+	}
+}
 
 func DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	return defaultDialer.DialContext(ctx, addr)
+}
+
+// DialConfig is like DialContext, but takes already parsed config.
+// No escaping is needed, so password, host and target may contain any characters.
+func DialConfig(ctx context.Context, config Config) (net.Conn, error) {
+	return defaultDialer.DialConfig(ctx, config)
+}
+
+func (d *Dialer) DialContext(ctx context.Context, addr string) (net.Conn, error) {
 	config, err := ParseAddr(addr)
 	if err != nil {
 		return nil, err
 	}
-	if err = config.canDial(); err != nil {
+	return d.DialConfig(ctx, config)
+}
+
+func (d *Dialer) DialConfig(ctx context.Context, config Config) (net.Conn, error) {
+	config = config.withEnv(d.lookupEnv)
+	if err := config.canDial(); err != nil {
 		return nil, wrapErr(err)
 	}
+	if conn, err := d.dialControlMaster(ctx, config); conn != nil || err != nil {
+		return conn, wrapErr(err)
+	}
 
-	kaConfig := makeKeepAliveConfig(config.Params)
-	if useConnMux(config.Params) {
-		return newMuxConn(ctx, config, kaConfig)
+	conn, release, err := openOnClient(ctx, d, config, func(ctx context.Context, cli sshClient) (net.Conn, error) {
+		conn, err := cli.DialContext(ctx, config.Net, config.Addr)
+		if trace := ContextTrace(ctx); trace != nil && trace.ChannelOpenDone != nil {
+			trace.ChannelOpenDone(config.Net, config.Addr, err)
+		}
+		return conn, err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return newClientConn(ctx, config, kaConfig)
+	return &tunnelConn{Conn: conn, release: release}, nil
 }
 
 func useConnMux(params url.Values) bool {
+	val, err := parseConnMux(params)
+	if err != nil {
+		logger().Warn("mytunne/dial: invalid ConnMux, ignore", "err", err)
+	}
+	return val
+}
+
+func parseConnMux(params url.Values) (bool, error) {
 	vals := params["ConnMux"]
 	switch len(vals) {
 	case 0:
-		return true
+		return true, nil
 	case 1:
 	default:
-		logger().Warn("mytunne/dial: multiple values for ConnMux, ignore")
-		return true
+		return true, errors.New("multiple values for ConnMux")
 	}
 	val, err := strconv.ParseBool(vals[0])
 	if err != nil {
-		logger().Warn("mytunne/dial: invalid value for ConnMux, ignore", "err", err)
-		return true
+		return true, fmt.Errorf("invalid value for ConnMux: %w", err)
 	}
-	return val
+	return val, nil
 }
 
-func newClientConn(ctx context.Context, config Config, kaConfig keepAliveConfig) (net.Conn, error) {
-	cli, err := newSshClient(ctx, config, kaConfig.keepAlive())
+// openOnClient opens a connection or a session on a new or a pooled ssh client, depending on ConnMux.
+// release must be called, when the result is closed.
+func openOnClient[T any](ctx context.Context, d *Dialer, config Config, open func(ctx context.Context, cli sshClient) (T, error)) (T, func() error, error) {
+	kaConfig := makeKeepAliveConfig(config.Params)
+	if useConnMux(config.Params) {
+		return openOnMuxClient(ctx, d, config, kaConfig, open)
+	}
+
+	var zero T
+	cli, err := d.newSshClient(ctx, config, kaConfig.keepAlive())
 	if err != nil {
-		return nil, wrapErr(err)
+		return zero, nil, wrapErr(err)
 	}
 
-	conn, err := cli.DialContext(ctx, config.Net, config.Addr)
+	res, err := open(ctx, cli)
 	if err != nil {
 		_ = cli.Close()
-		return nil, wrapErr(err)
+		return zero, nil, wrapErr(err)
 	}
 
 	if kaConfig.keepAlive() {
-		keepAlive(cli, kaConfig)
+		keepAlive(cli, kaConfig, d.clock)
 	}
-	return &clientConn{Conn: conn, cli: cli}, nil
+	return res, cli.Close, nil
 }
 
-func newMuxConn(ctx context.Context, config Config, kaConfig keepAliveConfig) (net.Conn, error) {
+func openOnMuxClient[T any](ctx context.Context, d *Dialer, config Config, kaConfig keepAliveConfig, open func(ctx context.Context, cli sshClient) (T, error)) (T, func() error, error) {
 	var (
 		ka      = kaConfig.keepAlive()
+		zero    T
 		lastErr error
 	)
 	for range 2 {
-		tunn, err := clientPool.acquire(ctx, config.clientKey(kaConfig),
+		tunn, err := d.pool.acquire(ctx, config.clientKey(kaConfig),
 			func(ctx context.Context) (sshClient, error) {
-				return newSshClient(ctx, config, ka)
+				return d.newSshClient(ctx, config, ka)
 			},
 		)
 		if err != nil {
-			return nil, wrapErr(err)
+			return zero, nil, wrapErr(err)
 		}
 
-		conn, err := tunn.client.DialContext(ctx, config.Net, config.Addr)
+		res, err := open(ctx, tunn.client)
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) || errors.Is(err, errAgentForwardingDenied) {
+			// the server has rejected the channel, but the client is still valid
+			_ = tunn.release()
+			return zero, nil, wrapErr(err)
+		}
 		if err != nil {
 			// if client can't dial - it is invalid
 			// forget it and start over
@@ -97,16 +185,25 @@ func newMuxConn(ctx context.Context, config Config, kaConfig keepAliveConfig) (n
 
 		if ka {
 			tunn.keepAliveOnce.Do(func() {
-				keepAlive(tunn.client, kaConfig)
+				keepAlive(tunn.client, kaConfig, d.clock)
 			})
 		}
 
-		return &muxClientConn{Conn: conn, tunn: tunn}, nil
+		return res, tunn.release, nil
 	}
-	return nil, wrapErr(lastErr)
+	return zero, nil, wrapErr(lastErr)
 }
 
 func (c Config) canDial() error {
+	errs := c.canConnect()
+	if c.Net == "" || c.Addr == "" {
+		errs = append(errs, ErrAddrRequired)
+	}
+	return errors.Join(errs...)
+}
+
+// canConnect checks config of ssh client, target is not required
+func (c Config) canConnect() []error {
 	var errs []error
 	if c.Username == "" {
 		errs = append(errs, ErrUserRequired)
@@ -114,34 +211,23 @@ func (c Config) canDial() error {
 	if c.Host == "" {
 		errs = append(errs, ErrHostRequired)
 	}
-	if c.Net == "" || c.Addr == "" {
-		errs = append(errs, ErrAddrRequired)
-	}
-	return errors.Join(errs...)
-}
-
-type clientConn struct {
-	net.Conn
-	cli io.Closer
-}
-
-func (t *clientConn) Close() error {
-	return errors.Join(t.Conn.Close(), t.cli.Close())
+	return errs
 }
 
-type muxClientConn struct {
+// tunnelConn releases ssh client, when closed
+type tunnelConn struct {
 	net.Conn
-	tunn  *sshPooledTunnel
-	close sync.Once
+	release func() error
+	close   sync.Once
 }
 
-func (t *muxClientConn) Close() error {
+func (t *tunnelConn) Close() error {
 	var connErr = t.Conn.Close()
-	var tunnErr error
+	var releaseErr error
 	t.close.Do(func() {
-		tunnErr = t.tunn.release()
+		releaseErr = t.release()
 	})
-	return errors.Join(connErr, tunnErr)
+	return errors.Join(connErr, releaseErr)
 }
 
 func wrapErr(err error) error {
@@ -153,6 +239,7 @@ func wrapErr(err error) error {
 
 type sshClient interface {
 	DialContext(ctx context.Context, net string, addr string) (net.Conn, error)
+	NewSession() (*ssh.Session, error)
 	SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error)
 	Close() error
 	Wait() error
@@ -162,25 +249,37 @@ type sshClient interface {
 type sshClientConn struct {
 	*ssh.Client
 	conn *netConn
+	// keyring is the ssh agent, which has authenticated the client, nil without agent
+	keyring *agentKeyring
+}
+
+func (c *sshClientConn) Close() error {
+	err := c.Client.Close()
+	if c.keyring != nil {
+		_ = c.keyring.Close()
+	}
+	return err
 }
 
 func (c *sshClientConn) successfulRead() <-chan struct{} {
 	return c.conn.readCh
 }
 
-func newSshClient(ctx context.Context, config Config, keepAlive bool) (sshClient, error) {
-	if useMockSshClient {
+func (d *Dialer) newSshClient(ctx context.Context, config Config, keepAlive bool) (sshClient, error) {
+	if d.backend != nil {
 		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
-		return newMockSshClient(), nil
+		return d.backend.newClient(), nil
 	}
 
-	home, err := os.UserHomeDir()
+	// containers may have no home, then keys and known hosts must be set explicitly
+	home, homeErr := os.UserHomeDir()
+	hostsFiles, err := knownHostsFiles(config, home, homeErr)
 	if err != nil {
-		return nil, fmt.Errorf("cannot determine home directory: %w", err)
+		return nil, err
 	}
-	hostKeyCallback, err := kh.New(filepath.Join(home, ".ssh/known_hosts"))
+	hostKeyCallback, err := kh.New(hostsFiles...)
 	if err != nil {
 		return nil, err
 	}
@@ -190,46 +289,119 @@ func newSshClient(ctx context.Context, config Config, keepAlive bool) (sshClient
 			User:            config.Username,
 			HostKeyCallback: hostKeyCallback,
 		}
-		authDone       func()
 		authMethodsErr error
+		auth           = new(authResult)
+		keyring        *agentKeyring
 	)
-	sshConfig.Auth, authDone, authMethodsErr = makeSshAuth(ctx, home, config)
-	if authDone != nil {
-		defer authDone()
+	secrets, secretsErr := d.authSecrets(config, home)
+	agentSock, agentErr := agentPath(config, home)
+	if agentSock != "" {
+		keyring = newAgentKeyring(agentSock)
 	}
+	sshConfig.Auth, authMethodsErr = makeSshAuth(ctx, home, config, secrets, d.keys, keyring, auth)
+	authMethodsErr = errors.Join(secretsErr, agentErr, authMethodsErr)
 
 	// Connect to the SSH Server
-	client, err := sshDialCtx(ctx, config.sshAddr(), sshConfig, keepAlive)
+	client, err := d.sshDialCtx(ctx, config, sshConfig, keepAlive)
+	if client != nil {
+		// agent lives with the client
+		client.keyring = keyring
+		if err == nil {
+			err = forwardAgent(client, config)
+			if err != nil {
+				_ = client.Close()
+				client = nil
+			}
+		}
+	} else if keyring != nil {
+		_ = keyring.Close()
+	}
 	if err != nil {
+		if isAuthError(err) {
+			// the secret may be rotated, read it again next time
+			secrets.invalidate()
+		}
 		if authMethodsErr != nil {
 			err = fmt.Errorf("%w; errors in auth process: %s", err, authMethodsErr)
 		}
+	}
+
+	info := auth.info()
+	if client != nil {
+		info.ServerVersion = string(client.ServerVersion())
+	}
+	if trace := ContextTrace(ctx); trace != nil && trace.HandshakeDone != nil {
+		trace.HandshakeDone(info, err)
+	}
+	if err != nil {
 		return nil, err
 	}
+	if info.PublicKey != nil {
+		logger().Debug("mytunnel/dial: authenticated", "config", config, "method", info.AuthMethod, "server", info.ServerVersion,
+			"key", ssh.FingerprintSHA256(info.PublicKey), "source", info.KeySource)
+	} else {
+		logger().Debug("mytunnel/dial: authenticated", "config", config, "method", info.AuthMethod, "server", info.ServerVersion)
+	}
 
 	return client, nil
 }
 
+// forwardAgent serves auth-agent@openssh.com channels of the client, if ForwardAgent is set.
+// Sessions still have to request forwarding, see NewSessionConfig.
+func forwardAgent(client *sshClientConn, config Config) error {
+	if !useForwardAgent(config.Params) {
+		return nil
+	}
+	if client.keyring == nil {
+		logger().Warn("mytunnel/dial: ForwardAgent is set, but there is no ssh agent", "config", config)
+		return nil
+	}
+	if err := agent.ForwardToAgent(client.Client, forwardedAgent{keyring: client.keyring}); err != nil {
+		return fmt.Errorf("forward agent: %w", err)
+	}
+	return nil
+}
+
 func (c Config) sshAddr() string {
 	port := c.Port
 	if port == 0 {
 		port = DefaultPort
 	}
-	return fmt.Sprintf("%s:%d", c.Host, port)
+	return net.JoinHostPort(c.Host, strconv.Itoa(port))
+}
+
+// knownHostsFiles returns UserKnownHostsFile params or ~/.ssh/known_hosts. The latter requires home.
+func knownHostsFiles(config Config, home string, homeErr error) ([]string, error) {
+	files := config.Params["UserKnownHostsFile"]
+	if len(files) == 0 {
+		if homeErr != nil {
+			return nil, fmt.Errorf("cannot determine home directory, set UserKnownHostsFile: %w", homeErr)
+		}
+		return []string{filepath.Join(home, ".ssh/known_hosts")}, nil
+	}
+	res := make([]string, len(files))
+	for i, file := range files {
+		res[i] = expandHome(file, home)
+	}
+	return res, nil
 }
 
-func sshDialCtx(ctx context.Context, addr string, config *ssh.ClientConfig, keepAlive bool) (*sshClientConn, error) {
+func (d *Dialer) sshDialCtx(ctx context.Context, tunnel Config, config *ssh.ClientConfig, keepAlive bool) (*sshClientConn, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	d := net.Dialer{
-		KeepAliveConfig: net.KeepAliveConfig{Enable: true},
+	addr := tunnel.sshAddr()
+	conn, err := d.dialTransport(ctx, tunnel, addr)
+	if trace := ContextTrace(ctx); trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone(addr, err)
 	}
-	conn, err := d.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	if d.faults != nil {
+		conn = d.faults.wrap(conn)
+	}
 	nConn := &netConn{Conn: conn}
 	if keepAlive {
 		nConn.readCh = make(chan struct{}, 1)
@@ -266,8 +438,30 @@ func sshDialCtx(ctx context.Context, addr string, config *ssh.ClientConfig, keep
 			return nil, res.err
 		}
 
-		return &sshClientConn{res.client, nConn}, nil
+		return &sshClientConn{Client: res.client, conn: nConn}, nil
+	}
+}
+
+// dialTransport connects to the ssh server directly or through ProxyJump host.
+func (d *Dialer) dialTransport(ctx context.Context, tunnel Config, addr string) (net.Conn, error) {
+	jump, err := tunnel.proxyJump()
+	if err != nil {
+		return nil, err
+	}
+	if jump == nil {
+		netDialer := net.Dialer{
+			KeepAliveConfig: net.KeepAliveConfig{Enable: true},
+		}
+		return netDialer.DialContext(ctx, "tcp", addr)
+	}
+
+	jump.Net, jump.Addr = "tcp", addr
+	// trace hooks are for the target client
+	conn, err := d.DialConfig(WithTrace(ctx, nil), *jump)
+	if err != nil {
+		return nil, fmt.Errorf("proxy jump %s: %w", jump.sshAddr(), err)
 	}
+	return conn, nil
 }
 
 func sshNewClient(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
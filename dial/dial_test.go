@@ -3,7 +3,9 @@ package dial
 import (
 	"context"
 	"fmt"
+	"io"
 	"math/rand/v2"
+	"net"
 	"runtime"
 	"sync/atomic"
 	"testing"
@@ -11,8 +13,18 @@ import (
 )
 
 func BenchmarkDialContext(b *testing.B) {
-	useMockSshClient = true
-	mockClosedCount.Store(0)
+	backend := NewMemoryBackend()
+	backend.Handle("/my.sock", func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+		_ = conn.Close()
+	})
+	backend.OnDial(func(string, string) error {
+		if rand.IntN(50) == 0 {
+			return io.EOF
+		}
+		return nil
+	})
+	dialer := NewDialer(WithMemoryBackend(backend))
 
 	var dialCount atomic.Int64
 	var addrs = make([]string, 0, runtime.GOMAXPROCS(0)+2)
@@ -26,7 +38,7 @@ func BenchmarkDialContext(b *testing.B) {
 		ctx, cancel := maybeTimeoutCtx()
 		defer cancel()
 		addr := addrs[rand.N(len(addrs))]
-		con, err := DialContext(ctx, addr)
+		con, err := dialer.DialContext(ctx, addr)
 		if err != nil {
 			return
 		}
@@ -43,7 +55,8 @@ func BenchmarkDialContext(b *testing.B) {
 		}
 	})
 
-	b.Logf("dial count: %d, closed: %d", dialCount.Load(), mockClosedCount.Load())
+	created, closed := backend.Clients()
+	b.Logf("dial count: %d, clients created: %d, closed: %d", dialCount.Load(), created, closed)
 }
 
 func maybeTimeoutCtx() (context.Context, func()) {
@@ -0,0 +1,448 @@
+// Package dialtest provides an in-process ssh server for end-to-end tests of tunnels.
+package dialtest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Config configures Server. Zero value accepts nobody.
+type Config struct {
+	// HostKey is the server host key. A new ed25519 key is generated, if nil.
+	HostKey ssh.Signer
+	// Passwords maps accepted users to their passwords.
+	Passwords map[string]string
+	// AuthorizedKeys maps accepted users to their public keys.
+	AuthorizedKeys map[string][]ssh.PublicKey
+	// KeyboardInteractive challenges the user, nil error accepts it. keyboard-interactive auth is disabled, if nil.
+	KeyboardInteractive func(user string, challenge ssh.KeyboardInteractiveChallenge) error
+	// MaxAuthTries limits failed auth attempts per connection, see ssh.ServerConfig.
+	MaxAuthTries int
+	// Forward dials targets of direct-tcpip and direct-streamlocal@openssh.com channels.
+	// By default, targets are dialed locally, so a test can listen them on loopback or in a temp dir.
+	Forward func(ctx context.Context, network, addr string) (net.Conn, error)
+	// RejectChannel is called before every forwarding. Non-nil error rejects the channel with the error text.
+	RejectChannel func(network, addr string) error
+	// Exec runs the command of an "exec" request and returns its exit status.
+	// Session channels are rejected, if nil.
+	Exec func(cmd string, stdin io.Reader, stdout, stderr io.Writer) int
+	// ExecAgent is Exec, which also gets the agent forwarded by the client, or nil, if forwarding was not requested.
+	// auth-agent-req@openssh.com requests are refused, if nil. Exec takes precedence.
+	ExecAgent func(cmd string, agent agent.ExtendedAgent, stdin io.Reader, stdout, stderr io.Writer) int
+	// SFTPDir serves the sftp subsystem. Relative paths are resolved from SFTPDir, absolute ones are not restricted.
+	// sftp is refused, if empty.
+	SFTPDir string
+}
+
+// Server is an ssh server listening on a loopback port.
+type Server struct {
+	// Addr is host:port of the server.
+	Addr string
+
+	config    Config
+	sshConfig *ssh.ServerConfig
+	listener  net.Listener
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	signals []string
+
+	handshakes atomic.Int64
+	channels   atomic.Int64
+}
+
+// NewServer starts a server on 127.0.0.1 with a random port.
+func NewServer(config Config) (*Server, error) {
+	if config.HostKey == nil {
+		key, err := GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		config.HostKey, err = ssh.NewSignerFromKey(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.Forward == nil {
+		var d net.Dialer
+		config.Forward = d.DialContext
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		config:   config,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.sshConfig = &ssh.ServerConfig{
+		PasswordCallback:  s.checkPassword,
+		PublicKeyCallback: s.checkPublicKey,
+		MaxAuthTries:      config.MaxAuthTries,
+	}
+	if config.KeyboardInteractive != nil {
+		s.sshConfig.KeyboardInteractiveCallback = s.checkKeyboardInteractive
+	}
+	s.sshConfig.AddHostKey(config.HostKey)
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server and closes all connections.
+func (s *Server) Close() error {
+	s.cancel()
+	err := s.listener.Close()
+	s.DropConnections()
+	s.wg.Wait()
+	return err
+}
+
+// Host returns the server ip.
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr)
+	return host
+}
+
+// Port returns the server port.
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.Addr)
+	res, _ := strconv.Atoi(port)
+	return res
+}
+
+// Handshakes returns the number of successfully authenticated connections.
+func (s *Server) Handshakes() int {
+	return int(s.handshakes.Load())
+}
+
+// Channels returns the number of accepted forwarding channels.
+func (s *Server) Channels() int {
+	return int(s.channels.Load())
+}
+
+// Signals returns names of signals (e.g. "TERM"), sent to running commands.
+func (s *Server) Signals() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.signals)
+}
+
+// DropConnections abruptly closes all transport connections, as if the network has failed.
+// The server continues to accept new ones.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+// KnownHostsLine returns a known_hosts line, which trusts the server.
+func (s *Server) KnownHostsLine() string {
+	return knownhosts.Line([]string{s.Addr}, s.config.HostKey.PublicKey())
+}
+
+// WriteHome prepares dir to be used as a home directory by dial:
+// writes .ssh/known_hosts, which trusts the server, and .ssh/id_ed25519 with key, if key is not nil.
+func (s *Server) WriteHome(dir string, key crypto.PrivateKey) error {
+	sshDir := filepath.Join(dir, ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return err
+	}
+	err := os.WriteFile(filepath.Join(sshDir, "known_hosts"), []byte(s.KnownHostsLine()+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return nil
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sshDir, "id_ed25519"), pem.EncodeToMemory(block), 0600)
+}
+
+// GenerateKey returns a new ed25519 key for a host or a user.
+func GenerateKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+var errDenied = errors.New("dialtest: access denied")
+
+func (s *Server) checkPassword(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	expected, has := s.config.Passwords[meta.User()]
+	if has && expected == string(password) {
+		return nil, nil
+	}
+	return nil, errDenied
+}
+
+func (s *Server) checkKeyboardInteractive(meta ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	if err := s.config.KeyboardInteractive(meta.User(), challenge); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (s *Server) checkPublicKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	for _, authorized := range s.config.AuthorizedKeys[meta.User()] {
+		if string(authorized.Marshal()) == string(key.Marshal()) {
+			return nil, nil
+		}
+	}
+	return nil, errDenied
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) track(conn net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	s.track(conn, true)
+	defer s.track(conn, false)
+	defer func() { _ = conn.Close() }()
+
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig)
+	if err != nil {
+		return
+	}
+	s.handshakes.Add(1)
+	go handleGlobalRequests(reqs)
+
+	for newChan := range chans {
+		s.wg.Add(1)
+		go s.handleChannel(sshConn, newChan)
+	}
+	_ = sshConn.Wait()
+}
+
+func handleGlobalRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		if req.WantReply {
+			// keepalive@openssh.com and others
+			_ = req.Reply(req.Type == "keepalive@openssh.com", nil)
+		}
+	}
+}
+
+// RFC 4254 7.2
+type directTCPIPMsg struct {
+	Host     string
+	Port     uint32
+	OrigHost string
+	OrigPort uint32
+}
+
+// openssh PROTOCOL 2.4
+type directStreamLocalMsg struct {
+	SocketPath string
+	Reserved0  string
+	Reserved1  uint32
+}
+
+func (s *Server) handleChannel(conn ssh.Conn, newChan ssh.NewChannel) {
+	defer s.wg.Done()
+
+	var network, addr string
+	switch newChan.ChannelType() {
+	case "direct-tcpip":
+		var msg directTCPIPMsg
+		if err := ssh.Unmarshal(newChan.ExtraData(), &msg); err != nil {
+			_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+			return
+		}
+		network, addr = "tcp", net.JoinHostPort(msg.Host, strconv.Itoa(int(msg.Port)))
+	case "session":
+		s.handleSession(conn, newChan)
+		return
+	case "direct-streamlocal@openssh.com":
+		var msg directStreamLocalMsg
+		if err := ssh.Unmarshal(newChan.ExtraData(), &msg); err != nil {
+			_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+			return
+		}
+		network, addr = "unix", msg.SocketPath
+	default:
+		_ = newChan.Reject(ssh.UnknownChannelType, fmt.Sprintf("unsupported channel type %s", newChan.ChannelType()))
+		return
+	}
+
+	if s.config.RejectChannel != nil {
+		if err := s.config.RejectChannel(network, addr); err != nil {
+			_ = newChan.Reject(ssh.Prohibited, err.Error())
+			return
+		}
+	}
+
+	target, err := s.config.Forward(s.ctx, network, addr)
+	if err != nil {
+		_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer func() { _ = target.Close() }()
+
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = ch.Close() }()
+	s.channels.Add(1)
+	go ssh.DiscardRequests(reqs)
+
+	targetDone := make(chan struct{})
+	go func() {
+		defer close(targetDone)
+		_, _ = io.Copy(ch, target)
+		_ = ch.Close()
+	}()
+	_, _ = io.Copy(target, ch)
+	if cw, ok := target.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	}
+	select {
+	case <-targetDone:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *Server) handleSession(conn ssh.Conn, newChan ssh.NewChannel) {
+	if s.config.Exec == nil && s.config.ExecAgent == nil && s.config.SFTPDir == "" {
+		_ = newChan.Reject(ssh.Prohibited, "sessions are disabled")
+		return
+	}
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = ch.Close() }()
+	s.channels.Add(1)
+
+	forwardAgent := false
+	for req := range reqs {
+		if req.Type == "auth-agent-req@openssh.com" && s.config.ExecAgent != nil {
+			forwardAgent = true
+			_ = req.Reply(true, nil)
+			continue
+		}
+		if req.Type == "subsystem" && s.config.SFTPDir != "" {
+			var msg struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &msg); err != nil || msg.Name != "sftp" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+			s.serveSFTP(ch)
+			return
+		}
+		if req.Type != "exec" || (s.config.Exec == nil && s.config.ExecAgent == nil) {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var msg struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+		go s.handleSignals(reqs)
+
+		var status int
+		if s.config.Exec != nil {
+			status = s.config.Exec(msg.Command, ch, ch, ch.Stderr())
+		} else {
+			status = s.execAgent(conn, forwardAgent, msg.Command, ch)
+		}
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+		return
+	}
+}
+
+func (s *Server) serveSFTP(ch ssh.Channel) {
+	srv, err := sftp.NewServer(ch, sftp.WithServerWorkingDirectory(s.config.SFTPDir))
+	if err != nil {
+		return
+	}
+	defer func() { _ = srv.Close() }()
+	_ = srv.Serve()
+}
+
+// handleSignals records "signal" requests of a running command
+func (s *Server) handleSignals(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		if req.Type == "signal" {
+			var msg struct{ Signal string }
+			if err := ssh.Unmarshal(req.Payload, &msg); err == nil {
+				s.mu.Lock()
+				s.signals = append(s.signals, msg.Signal)
+				s.mu.Unlock()
+			}
+		}
+		if req.WantReply {
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *Server) execAgent(conn ssh.Conn, forwardAgent bool, cmd string, ch ssh.Channel) int {
+	if !forwardAgent {
+		return s.config.ExecAgent(cmd, nil, ch, ch, ch.Stderr())
+	}
+	agentCh, reqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		_, _ = fmt.Fprintf(ch.Stderr(), "open agent channel: %s\n", err)
+		return 255
+	}
+	defer func() { _ = agentCh.Close() }()
+	go ssh.DiscardRequests(reqs)
+	return s.config.ExecAgent(cmd, agent.NewClient(agentCh), ch, ch, ch.Stderr())
+}
@@ -0,0 +1,38 @@
+package dialtest
+
+import (
+	"crypto"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Start starts a server for the test. It is closed on the test cleanup.
+func Start(t testing.TB, config Config) *Server {
+	t.Helper()
+	srv, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv
+}
+
+// SetupHome makes a temp dir the home of the test, see WriteHome.
+// SSH_AUTH_SOCK and MYTUNNEL_* variables are cleared, so the environment of the developer doesn't leak into the test.
+// The home directory is returned.
+func (s *Server) SetupHome(t testing.TB, key crypto.PrivateKey) string {
+	t.Helper()
+	home := t.TempDir()
+	if err := s.WriteHome(home, key); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, "MYTUNNEL_") {
+			t.Setenv(name, "")
+		}
+	}
+	return home
+}
@@ -0,0 +1,653 @@
+package dial
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialContextE2E(t *testing.T) {
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords:      map[string]string{"pass_user": "secret"},
+		AuthorizedKeys: map[string][]ssh.PublicKey{"key_user": {userPub}},
+	})
+	srv.SetupHome(t, userKey)
+
+	tcpTarget := listenEcho(t, "tcp", "127.0.0.1:0")
+	_, tcpPort, _ := net.SplitHostPort(tcpTarget)
+	unixTarget := listenEcho(t, "unix", filepath.Join(t.TempDir(), "echo.sock"))
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr bool
+	}{
+		{
+			name: "password tcp",
+			addr: fmt.Sprintf("pass_user:secret@%s/%s", srv.Addr, tcpTarget),
+		},
+		{
+			name: "password unix",
+			addr: fmt.Sprintf("pass_user:secret@%s%s", srv.Addr, unixTarget),
+		},
+		{
+			name: "hostname tcp",
+			addr: fmt.Sprintf("pass_user:secret@%s/tcp://localhost:%s", srv.Addr, tcpPort),
+		},
+		{
+			name: "publickey tcp",
+			addr: fmt.Sprintf("key_user@%s/%s", srv.Addr, tcpTarget),
+		},
+		{
+			name: "no conn mux",
+			addr: fmt.Sprintf("key_user@%s/%s?ConnMux=false", srv.Addr, tcpTarget),
+		},
+		{
+			name:    "wrong password",
+			addr:    fmt.Sprintf("pass_user:wrong@%s/%s", srv.Addr, tcpTarget),
+			wantErr: true,
+		},
+		{
+			name:    "unknown user",
+			addr:    fmt.Sprintf("nobody@%s/%s", srv.Addr, tcpTarget),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := DialContext(testCtx(t), tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DialContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			assertEcho(t, conn)
+			if err = conn.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDialContextRejectedChannel(t *testing.T) {
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+		RejectChannel: func(network, addr string) error {
+			if network == "unix" {
+				return errors.New("no unix sockets")
+			}
+			return nil
+		},
+	})
+	srv.SetupHome(t, nil)
+	tcpTarget := listenEcho(t, "tcp", "127.0.0.1:0")
+
+	conn, err := DialContext(testCtx(t), fmt.Sprintf("user:pass@%s/%s", srv.Addr, tcpTarget))
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, err = DialContext(testCtx(t), fmt.Sprintf("user:pass@%s/tmp/my.sock", srv.Addr))
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("DialContext() error = %v, want OpenChannelError", err)
+	}
+
+	// pooled client survives the rejected channel
+	assertEcho(t, conn)
+	if srv.Handshakes() != 1 {
+		t.Errorf("Handshakes() = %d, want 1", srv.Handshakes())
+	}
+}
+
+func TestDialContextDroppedTransport(t *testing.T) {
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+	})
+	srv.SetupHome(t, nil)
+	addr := fmt.Sprintf("user:pass@%s/%s", srv.Addr, listenEcho(t, "tcp", "127.0.0.1:0"))
+
+	conn, err := DialContext(testCtx(t), addr)
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	assertEcho(t, conn)
+
+	srv.DropConnections()
+
+	// dead pooled client is forgotten and replaced
+	conn2, err := DialContext(testCtx(t), addr)
+	if err != nil {
+		t.Fatalf("DialContext() after drop error = %v", err)
+	}
+	defer func() { _ = conn2.Close() }()
+	assertEcho(t, conn2)
+	if srv.Handshakes() != 2 {
+		t.Errorf("Handshakes() = %d, want 2", srv.Handshakes())
+	}
+}
+
+func TestDialContextProxyJump(t *testing.T) {
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	jump := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"jump_user": "secret"},
+	})
+	target := dialtest.Start(t, dialtest.Config{
+		AuthorizedKeys: map[string][]ssh.PublicKey{"key_user": {userPub}},
+	})
+	// home trusts only the jump host, the target is trusted by UserKnownHostsFile
+	jump.SetupHome(t, nil)
+	dir := t.TempDir()
+	knownHosts := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte(target.KnownHostsLine()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// env applies to all hops, so the file trusts both hosts
+	knownHostsAll := filepath.Join(dir, "known_hosts_all")
+	if err := os.WriteFile(knownHostsAll, []byte(jump.KnownHostsLine()+"\n"+target.KnownHostsLine()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	identity := writeKey(t, filepath.Join(dir, "key"), userKey, "")
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+	jumpAddr := "jump_user:secret@" + jump.Addr
+
+	tests := []struct {
+		name   string
+		params url.Values
+		env    map[string]string
+	}{
+		{
+			name: "params",
+			params: url.Values{
+				"IdentityFile":       {identity},
+				"UserKnownHostsFile": {knownHosts},
+				"ProxyJump":          {jumpAddr},
+			},
+		},
+		{
+			name: "env",
+			env: map[string]string{
+				EnvUser:                  "key_user",
+				"MYTUNNEL_IDENTITY_FILE": identity,
+				"MYTUNNEL_KNOWN_HOSTS":   knownHostsAll,
+				"MYTUNNEL_PROXY_JUMP":    jumpAddr,
+			},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Host: target.Host(), Port: target.Port(), Net: "tcp", Addr: echo, Params: tt.params}
+			if tt.env == nil {
+				config.Username = "key_user"
+			}
+			d := NewDialer(WithLookupEnv(func(key string) (string, bool) {
+				val, has := tt.env[key]
+				return val, has
+			}))
+			conn, err := d.DialConfig(testCtx(t), config)
+			if err != nil {
+				t.Fatalf("DialConfig() error = %v", err)
+			}
+			assertEcho(t, conn)
+			if err = conn.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+			if jump.Handshakes() != i+1 || target.Handshakes() != i+1 {
+				t.Errorf("Handshakes() = %d, %d, want %d", jump.Handshakes(), target.Handshakes(), i+1)
+			}
+		})
+	}
+}
+
+func TestDialContextSecrets(t *testing.T) {
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords:      map[string]string{"pass_user": "secret"},
+		AuthorizedKeys: map[string][]ssh.PublicKey{"key_user": {userPub}},
+	})
+	srv.SetupHome(t, nil)
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	identity := writeKey(t, filepath.Join(dir, "key"), userKey, "passphrase")
+	t.Setenv("MYTUNNEL_TEST_PASSPHRASE", "passphrase")
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+
+	tests := []struct {
+		name    string
+		addr    string
+		opts    []Option
+		wantErr bool
+	}{
+		{
+			name: "password file",
+			addr: fmt.Sprintf("pass_user@%s/%s?PasswordFile=%s", srv.Addr, echo, url.QueryEscape(passwordFile)),
+		},
+		{
+			name: "password provider",
+			addr: fmt.Sprintf("pass_user@%s/%s", srv.Addr, echo),
+			opts: []Option{WithPasswordProvider(SecretFunc(func(ctx context.Context) (string, error) {
+				return "secret", nil
+			}))},
+		},
+		{
+			name:    "wrong password env",
+			addr:    fmt.Sprintf("pass_user@%s/%s?PasswordEnv=MYTUNNEL_TEST_PASSPHRASE", srv.Addr, echo),
+			wantErr: true,
+		},
+		{
+			name: "password command",
+			addr: fmt.Sprintf("pass_user@%s/%s?PasswordCommand=%s", srv.Addr, echo, url.QueryEscape("echo secret")),
+			opts: []Option{WithSecretCommands()},
+		},
+		{
+			name:    "password command disabled",
+			addr:    fmt.Sprintf("pass_user@%s/%s?PasswordCommand=%s", srv.Addr, echo, url.QueryEscape("echo secret")),
+			wantErr: true,
+		},
+		{
+			name: "passphrase env",
+			addr: fmt.Sprintf("key_user@%s/%s?IdentityFile=%s&PassphraseEnv=MYTUNNEL_TEST_PASSPHRASE", srv.Addr, echo, url.QueryEscape(identity)),
+		},
+		{
+			name:    "no passphrase",
+			addr:    fmt.Sprintf("key_user@%s/%s?IdentityFile=%s", srv.Addr, echo, url.QueryEscape(identity)),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := NewDialer(tt.opts...).DialContext(testCtx(t), tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DialContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			assertEcho(t, conn)
+			if err = conn.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDialContextSecretInvalidation(t *testing.T) {
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "secret"},
+	})
+	srv.SetupHome(t, nil)
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	setPassword := func(password string) {
+		t.Helper()
+		if err := os.WriteFile(passwordFile, []byte(password), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := l.Addr().String()
+	_ = l.Close()
+	dialer := NewDialer()
+	params := "?PasswordFile=" + url.QueryEscape(passwordFile)
+
+	// rejected password is dropped from the cache
+	setPassword("wrong")
+	if _, err = dialer.DialContext(testCtx(t), fmt.Sprintf("user@%s/%s%s", srv.Addr, echo, params)); err == nil {
+		t.Fatalf("DialContext() with wrong password: expected error")
+	}
+	setPassword("secret")
+	conn, err := dialer.DialContext(testCtx(t), fmt.Sprintf("user@%s/%s%s", srv.Addr, echo, params))
+	if err != nil {
+		t.Fatalf("DialContext() after rotation error = %v", err)
+	}
+	// the client is closed with its last connection, the next dial does a new handshake
+	_ = conn.Close()
+
+	// connection failures keep the cache
+	if _, err = dialer.DialContext(testCtx(t), fmt.Sprintf("user@%s/%s%s", refused, echo, params)); err == nil {
+		t.Fatalf("DialContext() to closed port: expected error")
+	}
+	setPassword("wrong")
+	conn, err = dialer.DialContext(testCtx(t), fmt.Sprintf("user@%s/%s%s", srv.Addr, echo, params))
+	if err != nil {
+		t.Fatalf("DialContext() with cached password error = %v", err)
+	}
+	_ = conn.Close()
+	if srv.Handshakes() != 2 {
+		t.Errorf("Handshakes() = %d, want 2", srv.Handshakes())
+	}
+}
+
+func TestDialContextKeyboardInteractive(t *testing.T) {
+	const totpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	key, err := decodeTOTPSecret(totpSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		KeyboardInteractive: func(user string, challenge ssh.KeyboardInteractiveChallenge) error {
+			answers, err := challenge(user, "password and code", []string{"Password: ", "Verification code: "}, []bool{false, true})
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			codes := []string{totpCode(key, now), totpCode(key, now.Add(-30*time.Second))}
+			if len(answers) != 2 || answers[0] != "secret" || (answers[1] != codes[0] && answers[1] != codes[1]) {
+				return errors.New("wrong answers")
+			}
+			return nil
+		},
+	})
+	srv.SetupHome(t, nil)
+	t.Setenv("MYTUNNEL_TEST_TOTP", totpSecret)
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+
+	tests := []struct {
+		name    string
+		addr    string
+		opts    []Option
+		wantErr bool
+	}{
+		{
+			name: "password and totp",
+			addr: fmt.Sprintf("otp_user:secret@%s/%s?TOTPSecretEnv=MYTUNNEL_TEST_TOTP", srv.Addr, echo),
+		},
+		{
+			name: "responder",
+			addr: fmt.Sprintf("otp_user@%s/%s", srv.Addr, echo),
+			opts: []Option{WithChallengeResponder(ChallengeFunc(func(ctx context.Context, name, instruction string, questions []string, echos []bool) ([]string, error) {
+				return []string{"secret", totpCode(key, time.Now())}, nil
+			}))},
+		},
+		{
+			name:    "no totp",
+			addr:    fmt.Sprintf("otp_user:secret@%s/%s", srv.Addr, echo),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method string
+			ctx := WithTrace(testCtx(t), &Trace{
+				HandshakeDone: func(info HandshakeInfo, err error) {
+					method = info.AuthMethod
+				},
+			})
+			conn, err := NewDialer(tt.opts...).DialContext(ctx, tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DialContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if method != "keyboard-interactive" {
+				t.Errorf("AuthMethod = %q, want keyboard-interactive", method)
+			}
+			assertEcho(t, conn)
+			if err = conn.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDialContextPreferredAuthentications(t *testing.T) {
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		AuthorizedKeys: map[string][]ssh.PublicKey{"key_user": {userPub}},
+		MaxAuthTries:   2,
+	})
+	srv.SetupHome(t, nil)
+	dir := t.TempDir()
+	identity := writeKey(t, filepath.Join(dir, "key"), userKey, "")
+	other := writeKey(t, filepath.Join(dir, "other"), otherKey, "")
+	// a copy of other key is not offered twice
+	otherCopy := writeKey(t, filepath.Join(dir, "other_copy"), otherKey, "")
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+	identities := "IdentityFile=" + url.QueryEscape(other) + "&IdentityFile=" + url.QueryEscape(otherCopy) + "&IdentityFile=" + url.QueryEscape(identity)
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr bool
+	}{
+		{
+			name: "publickey only",
+			addr: fmt.Sprintf("key_user:wrong@%s/%s?PreferredAuthentications=publickey&%s", srv.Addr, echo, identities),
+		},
+		{
+			name:    "password wastes a try",
+			addr:    fmt.Sprintf("key_user:wrong@%s/%s?%s", srv.Addr, echo, identities),
+			wantErr: true,
+		},
+		{
+			name:    "no publickey",
+			addr:    fmt.Sprintf("key_user:wrong@%s/%s?PreferredAuthentications=password&%s", srv.Addr, echo, identities),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info HandshakeInfo
+			ctx := WithTrace(testCtx(t), &Trace{
+				HandshakeDone: func(i HandshakeInfo, err error) {
+					info = i
+				},
+			})
+			conn, err := NewDialer().DialContext(ctx, tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DialContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if info.AuthMethod != "publickey" || info.KeySource != identity {
+				t.Errorf("HandshakeInfo = %s %s, want publickey %s", info.AuthMethod, info.KeySource, identity)
+			}
+			assertEcho(t, conn)
+			if err = conn.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func writeKey(t *testing.T, path string, key any, passphrase string) string {
+	t.Helper()
+	var (
+		block *pem.Block
+		err   error
+	)
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testCtx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func listenEcho(t *testing.T, network, addr string) string {
+	t.Helper()
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	const msg = "hello through the tunnel"
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(buf) != msg {
+		t.Errorf("Read() = %q, want %q", buf, msg)
+	}
+}
+
+func TestDialerFaultsEviction(t *testing.T) {
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+	})
+	srv.SetupHome(t, nil)
+	addr := fmt.Sprintf("user:pass@%s/%s", srv.Addr, listenEcho(t, "tcp", "127.0.0.1:0"))
+
+	faults := NewFaults()
+	dialer := NewDialer(WithFaults(faults))
+
+	conn, err := dialer.DialContext(testCtx(t), addr)
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	assertEcho(t, conn)
+
+	// break the only ssh client ↔ server connection
+	faults.CloseAfterBytes(1)
+	_, _ = conn.Write([]byte("hello"))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Read() expected error on broken transport")
+	}
+	faults.CloseAfterBytes(0)
+
+	conn2, err := dialer.DialContext(testCtx(t), addr)
+	if err != nil {
+		t.Fatalf("DialContext() after fault error = %v", err)
+	}
+	defer func() { _ = conn2.Close() }()
+	assertEcho(t, conn2)
+	if srv.Handshakes() != 2 {
+		t.Errorf("Handshakes() = %d, want 2", srv.Handshakes())
+	}
+}
+
+func TestDialContextTrace(t *testing.T) {
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		AuthorizedKeys: map[string][]ssh.PublicKey{"user": {userPub}},
+	})
+	srv.SetupHome(t, userKey)
+	target := listenEcho(t, "tcp", "127.0.0.1:0")
+
+	var (
+		stages    []string
+		handshake HandshakeInfo
+	)
+	ctx := WithTrace(testCtx(t), &Trace{
+		ConnectDone: func(addr string, err error) {
+			stages = append(stages, "connect")
+		},
+		HandshakeDone: func(info HandshakeInfo, err error) {
+			stages = append(stages, "handshake")
+			handshake = info
+		},
+		ChannelOpenDone: func(network, addr string, err error) {
+			stages = append(stages, "channel")
+		},
+	})
+	conn, err := NewDialer().DialContext(ctx, fmt.Sprintf("user@%s/%s", srv.Addr, target))
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	_ = conn.Close()
+
+	if fmt.Sprint(stages) != "[connect handshake channel]" {
+		t.Errorf("stages = %v", stages)
+	}
+	if handshake.AuthMethod != "publickey" {
+		t.Errorf("AuthMethod = %q, want publickey", handshake.AuthMethod)
+	}
+	if handshake.PublicKey == nil || ssh.FingerprintSHA256(handshake.PublicKey) != ssh.FingerprintSHA256(userPub) {
+		t.Errorf("PublicKey = %v, want %v", handshake.PublicKey, userPub)
+	}
+}
@@ -0,0 +1,109 @@
+package dial
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// EnvUser sets the username, if the address has none.
+const EnvUser = "MYTUNNEL_USER"
+
+type envParam struct {
+	env   string
+	param string
+	// list values are separated by os.PathListSeparator, each becomes a param value
+	list bool
+}
+
+// envParams are MYTUNNEL_* variables, which set params, not present in the address.
+var envParams = []envParam{
+	{env: "MYTUNNEL_IDENTITY_FILE", param: "IdentityFile", list: true},
+	{env: "MYTUNNEL_KNOWN_HOSTS", param: "UserKnownHostsFile", list: true},
+	{env: "MYTUNNEL_SERVER_ALIVE_INTERVAL", param: "ServerAliveInterval"},
+	{env: "MYTUNNEL_SERVER_ALIVE_COUNT_MAX", param: "ServerAliveCountMax"},
+	{env: "MYTUNNEL_SERVER_ALIVE_TIMEOUT", param: "ServerAliveTimeout"},
+	{env: "MYTUNNEL_SERVER_ALIVE_LAG_MAX", param: "ServerAliveLagMax"},
+	{env: "MYTUNNEL_CONN_MUX", param: "ConnMux"},
+	{env: "MYTUNNEL_PROXY_JUMP", param: "ProxyJump"},
+}
+
+// WithEnv returns a copy of the config with defaults from MYTUNNEL_* environment variables.
+// Params from the address take precedence over the environment.
+// Dialer applies it automatically, unless created WithLookupEnv(nil).
+// ~/.ssh/config is not read.
+func (c Config) WithEnv() Config {
+	return c.withEnv(os.LookupEnv)
+}
+
+func (c Config) withEnv(lookup func(string) (string, bool)) Config {
+	if lookup == nil {
+		return c
+	}
+	if c.Username == "" {
+		if user, has := lookup(EnvUser); has {
+			c.Username = user
+		}
+	}
+	params, cloned := c.Params, false
+	for _, p := range envParams {
+		val, has := lookup(p.env)
+		if !has || val == "" || hasParam(c.Params, p.param) {
+			continue
+		}
+		if !cloned {
+			// don't modify caller's params
+			params, cloned = cloneParams(c.Params), true
+		}
+		if p.list {
+			params[p.param] = slices.DeleteFunc(filepath.SplitList(val), func(s string) bool {
+				return strings.TrimSpace(s) == ""
+			})
+		} else {
+			params.Set(p.param, val)
+		}
+	}
+	c.Params = params
+	return c
+}
+
+// hasParam checks, if the param is set. Case-insensitive params are matched in any case.
+func hasParam(params url.Values, name string) bool {
+	if _, has := params[name]; has {
+		return true
+	}
+	idx := slices.IndexFunc(knownParams, func(p knownParam) bool { return p.name == name })
+	if idx >= 0 && knownParams[idx].caseSensitive {
+		return false
+	}
+	for k := range params {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneParams(params url.Values) url.Values {
+	res := make(url.Values, len(params)+1)
+	for k, v := range params {
+		res[k] = slices.Clone(v)
+	}
+	return res
+}
+
+// expandHome replaces leading ~ with the home directory. The path is kept as is without home.
+func expandHome(path, home string) string {
+	if home == "" {
+		return path
+	}
+	if path == "~" {
+		return home
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(home, rest)
+	}
+	return path
+}
@@ -0,0 +1,152 @@
+package dial
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestConfig_withEnv(t *testing.T) {
+	env := map[string]string{
+		EnvUser:                          "envuser",
+		"MYTUNNEL_IDENTITY_FILE":         "~/.ssh/a:/keys/b",
+		"MYTUNNEL_SERVER_ALIVE_INTERVAL": "10",
+		"MYTUNNEL_SERVER_ALIVE_TIMEOUT":  "",
+		"MYTUNNEL_CONN_MUX":              "false",
+	}
+	lookup := func(key string) (string, bool) {
+		val, has := env[key]
+		return val, has
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		want   Config
+	}{
+		{
+			name:   "defaults",
+			config: Config{Host: "host"},
+			want: Config{
+				Username: "envuser",
+				Host:     "host",
+				Params: url.Values{
+					"IdentityFile":        {"~/.ssh/a", "/keys/b"},
+					"ServerAliveInterval": {"10"},
+					"ConnMux":             {"false"},
+				},
+			},
+		},
+		{
+			name: "explicit wins",
+			config: Config{
+				Username: "user",
+				Host:     "host",
+				Params: url.Values{
+					"IdentityFile":        {"/keys/c"},
+					"serveraliveinterval": {"20"},
+					"ConnMux":             {"true"},
+				},
+			},
+			want: Config{
+				Username: "user",
+				Host:     "host",
+				Params: url.Values{
+					"IdentityFile":        {"/keys/c"},
+					"serveraliveinterval": {"20"},
+					"ConnMux":             {"true"},
+				},
+			},
+		},
+		{
+			name: "case sensitive param in another case",
+			config: Config{
+				Username: "user",
+				Params:   url.Values{"connmux": {"true"}},
+			},
+			want: Config{
+				Username: "user",
+				Params: url.Values{
+					"connmux":             {"true"},
+					"IdentityFile":        {"~/.ssh/a", "/keys/b"},
+					"ServerAliveInterval": {"10"},
+					"ConnMux":             {"false"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := cloneParams(tt.config.Params)
+			got := tt.config.withEnv(lookup)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withEnv() = %v, want %v", got.Params, tt.want.Params)
+			}
+			if len(params) > 0 && !reflect.DeepEqual(params, tt.config.Params) {
+				t.Errorf("withEnv() modified params: %v", tt.config.Params)
+			}
+		})
+	}
+
+	config := Config{Host: "host"}
+	if got := config.withEnv(nil); !reflect.DeepEqual(got, config) {
+		t.Errorf("withEnv(nil) = %v, want %v", got, config)
+	}
+}
+
+func TestConfig_proxyJump(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  url.Values
+		want    *Config
+		wantErr bool
+	}{
+		{
+			name: "no param",
+		},
+		{
+			name:   "none",
+			params: url.Values{"ProxyJump": {"none"}},
+		},
+		{
+			name:   "jump",
+			params: url.Values{"ProxyJump": {"user@jump:2222"}},
+			want: &Config{
+				Username: "user",
+				Host:     "jump",
+				Port:     2222,
+				Params:   url.Values{"ProxyJump": {"none"}},
+			},
+		},
+		{
+			name:   "chained jump",
+			params: url.Values{"ProxyJump": {"ssh://user@jump?ProxyJump=user%40first"}},
+			want: &Config{
+				Username: "user",
+				Host:     "jump",
+				Params:   url.Values{"ProxyJump": {"user@first"}},
+			},
+		},
+		{
+			name:    "target",
+			params:  url.Values{"ProxyJump": {"user@jump/127.0.0.1:22"}},
+			wantErr: true,
+		},
+		{
+			name:    "multiple",
+			params:  url.Values{"ProxyJump": {"user@a", "user@b"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Config{Params: tt.params}.proxyJump()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("proxyJump() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("proxyJump() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
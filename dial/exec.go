@@ -0,0 +1,84 @@
+package dial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecResult is the output of a command, run by Exec.
+type ExecResult struct {
+	Stdout     []byte
+	Stderr     []byte
+	ExitStatus int
+}
+
+func Exec(ctx context.Context, addr string, cmd string) (ExecResult, error) {
+	return defaultDialer.Exec(ctx, addr, cmd)
+}
+
+func ExecConfig(ctx context.Context, config Config, cmd string) (ExecResult, error) {
+	return defaultDialer.ExecConfig(ctx, config, cmd)
+}
+
+// Exec runs cmd on the ssh server of addr, using the same pooled client as connections to addr.
+// The target part of addr is ignored. A non-zero exit status is not an error,
+// but a command killed by a signal or without exit status is.
+// If ctx is done, the command is signaled and its session is closed, see Session.RunContext.
+func (d *Dialer) Exec(ctx context.Context, addr string, cmd string) (ExecResult, error) {
+	config, err := ParseAddr(addr)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	return d.ExecConfig(ctx, config, cmd)
+}
+
+func (d *Dialer) ExecConfig(ctx context.Context, config Config, cmd string) (ExecResult, error) {
+	session, err := d.NewSessionConfig(ctx, config)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer func() { _ = session.Close() }()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	err = session.RunContext(ctx, cmd)
+	res := ExecResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && exitErr.Signal() == "" {
+		res.ExitStatus = exitErr.ExitStatus()
+		err = nil
+	}
+	return res, wrapErr(err)
+}
+
+// RunContext is Run, which stops the command, when ctx is done: the remote command gets SIGTERM,
+// and the session is closed. ctx error is returned in this case.
+// Stdout and Stderr may be set to stream the output.
+func (s *Session) RunContext(ctx context.Context, cmd string) error {
+	if err := s.Start(cmd); err != nil {
+		return err
+	}
+	return s.WaitContext(ctx)
+}
+
+// WaitContext is Wait, which stops the started command, when ctx is done, see RunContext.
+func (s *Session) WaitContext(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// servers may ignore signals, closing the session stops the command anyway
+		_ = s.Signal(ssh.SIGTERM)
+		_ = s.Session.Close()
+		<-done
+		return ctx.Err()
+	}
+}
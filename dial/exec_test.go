@@ -0,0 +1,86 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+)
+
+func TestDialerExec(t *testing.T) {
+	release := make(chan struct{})
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+		Exec: func(cmd string, stdin io.Reader, stdout, stderr io.Writer) int {
+			switch cmd {
+			case "hang":
+				<-release
+				return 0
+			case "fail":
+				_, _ = io.WriteString(stderr, "no such file")
+				return 3
+			}
+			_, _ = io.WriteString(stdout, cmd)
+			return 0
+		},
+	})
+	// runs before srv.Close, which waits for hanging commands
+	t.Cleanup(func() { close(release) })
+	srv.SetupHome(t, nil)
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+	addr := fmt.Sprintf("user:pass@%s/%s", srv.Addr, echo)
+
+	d := NewDialer()
+	// the tunnel holds the pooled client, so commands don't need new handshakes
+	conn, err := d.DialContext(testCtx(t), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	tests := []struct {
+		name string
+		cmd  string
+		want ExecResult
+	}{
+		{name: "stdout", cmd: "mysqladmin status", want: ExecResult{Stdout: []byte("mysqladmin status")}},
+		{name: "exit status", cmd: "fail", want: ExecResult{Stderr: []byte("no such file"), ExitStatus: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.Exec(testCtx(t), addr, tt.cmd)
+			if err != nil {
+				t.Fatalf("Exec() error = %v", err)
+			}
+			if string(got.Stdout) != string(tt.want.Stdout) || string(got.Stderr) != string(tt.want.Stderr) || got.ExitStatus != tt.want.ExitStatus {
+				t.Errorf("Exec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(testCtx(t), 50*time.Millisecond)
+		defer cancel()
+		_, err := d.Exec(ctx, addr, "hang")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Exec() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+		deadline := time.Now().Add(time.Second)
+		for !slices.Contains(srv.Signals(), "TERM") {
+			if time.Now().After(deadline) {
+				t.Fatalf("Signals() = %v, want TERM", srv.Signals())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	if n := srv.Handshakes(); n != 1 {
+		t.Errorf("Handshakes() = %d, want 1", n)
+	}
+	assertEcho(t, conn)
+}
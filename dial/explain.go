@@ -0,0 +1,321 @@
+package dial
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Explanation describes, how a Config would be dialed.
+type Explanation struct {
+	Username string
+	// HasPassword reports, that password auth is configured: in the address or by Password* params.
+	HasPassword bool
+	// SSHAddr is host:port of the ssh server.
+	SSHAddr string
+	Net     string
+	Addr    string
+	ConnMux bool
+	// KeepAlive is false, if ServerAliveInterval is not set. Other keep alive fields are zero in this case.
+	KeepAlive           bool
+	ServerAliveInterval time.Duration
+	ServerAliveCountMax int
+	ServerAliveTimeout  time.Duration
+	ServerAliveLagMax   time.Duration
+	// AuthOrder is the order of auth methods, see PreferredAuthentications.
+	AuthOrder []string
+	// IdentityFiles are from IdentityFile params. Empty means ~/.ssh/id_* keys.
+	IdentityFiles []string
+	// KnownHostsFiles are from UserKnownHostsFile params. Empty means ~/.ssh/known_hosts.
+	KnownHostsFiles []string
+	// ProxyJump is the redacted jump host, empty if it is not used.
+	ProxyJump string
+	// ControlPath is the expanded OpenSSH control master socket, empty if it is not used.
+	ControlPath string
+	Warnings    []Warning
+}
+
+// Warning is a likely mistake in a Config.
+type Warning struct {
+	Code WarningCode
+	// Param is the name of the param, if the warning is about it.
+	Param   string
+	Message string
+}
+
+type WarningCode string
+
+const (
+	WarnMissingField    WarningCode = "missing-field"
+	WarnUnknownParam    WarningCode = "unknown-param"
+	WarnDuplicatedParam WarningCode = "duplicated-param"
+	WarnInvalidValue    WarningCode = "invalid-value"
+	WarnParamCase       WarningCode = "param-case"
+	WarnIneffective     WarningCode = "ineffective-param"
+	WarnHostAsPath      WarningCode = "host-as-path"
+	WarnPasswordInAddr  WarningCode = "password-in-addr"
+	WarnSecretCommand   WarningCode = "secret-command"
+)
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Code, w.Message)
+}
+
+type knownParam struct {
+	name string
+	// caseSensitive params are ignored, if written in another case
+	caseSensitive bool
+	// multiple values are allowed
+	multiple bool
+}
+
+// knownParams are all params, recognized by dial
+var knownParams = []knownParam{
+	{name: "ServerAliveInterval"},
+	{name: "ServerAliveCountMax"},
+	{name: "ServerAliveTimeout"},
+	{name: "ServerAliveLagMax"},
+	{name: "ConnMux", caseSensitive: true},
+	{name: "Net", caseSensitive: true},
+	{name: "IdentityFile", caseSensitive: true, multiple: true},
+	{name: "UserKnownHostsFile", caseSensitive: true, multiple: true},
+	{name: "ProxyJump", caseSensitive: true},
+	{name: "PasswordFile", caseSensitive: true},
+	{name: "PasswordEnv", caseSensitive: true},
+	{name: "PasswordCommand", caseSensitive: true},
+	{name: "PassphraseFile", caseSensitive: true},
+	{name: "PassphraseEnv", caseSensitive: true},
+	{name: "PassphraseCommand", caseSensitive: true},
+	{name: "TOTPSecretFile", caseSensitive: true},
+	{name: "TOTPSecretEnv", caseSensitive: true},
+	{name: "TOTPSecretCommand", caseSensitive: true},
+	{name: "SecretTTL", caseSensitive: true},
+	{name: "PreferredAuthentications", caseSensitive: true},
+	{name: "AgentKeys", caseSensitive: true},
+	{name: "IdentityAgent", caseSensitive: true},
+	{name: "ForwardAgent", caseSensitive: true},
+	{name: "ControlPath", caseSensitive: true},
+}
+
+// Explain resolves defaults and reports, what would be dialed. Nothing is dialed.
+func (c Config) Explain() Explanation {
+	kaConfig, _ := parseKeepAliveConfig(c.Params)
+	connMux, _ := parseConnMux(c.Params)
+	authOrder, _ := authOrder(c.Params)
+	controlPath, _ := c.controlPath()
+	var proxyJump string
+	if jump, _ := c.proxyJump(); jump != nil {
+		proxyJump = jump.Redacted()
+	}
+	return Explanation{
+		Username:            c.Username,
+		HasPassword:         c.Password != nil || firstParam(c.Params, passwordParams) != "",
+		SSHAddr:             c.sshAddr(),
+		Net:                 c.Net,
+		Addr:                c.Addr,
+		ConnMux:             connMux,
+		KeepAlive:           kaConfig.keepAlive(),
+		ServerAliveInterval: kaConfig.serverAliveInterval,
+		ServerAliveCountMax: kaConfig.serverAliveCountMax,
+		ServerAliveTimeout:  kaConfig.serverAliveTimeout,
+		ServerAliveLagMax:   kaConfig.serverAliveLagMax,
+		AuthOrder:           authOrder,
+		IdentityFiles:       c.Params["IdentityFile"],
+		KnownHostsFiles:     c.Params["UserKnownHostsFile"],
+		ProxyJump:           proxyJump,
+		ControlPath:         controlPath,
+		Warnings:            c.Lint(),
+	}
+}
+
+// Lint reports likely mistakes in the Config. Nothing is dialed.
+func (c Config) Lint() []Warning {
+	var warnings []Warning
+	warn := func(code WarningCode, param string, format string, args ...any) {
+		warnings = append(warnings, Warning{Code: code, Param: param, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, err := range c.canConnect() {
+		warn(WarnMissingField, "", "%s", err)
+	}
+	if c.Net == "" || c.Addr == "" {
+		warn(WarnMissingField, "", "%s, unless it is provided by an integration package", ErrAddrRequired)
+	}
+	if c.Password != nil {
+		warn(WarnPasswordInAddr, "", "password is stored in the address, prefer key auth or PasswordFile")
+	}
+	for _, names := range [][]string{passwordParams, passphraseParams, totpParams} {
+		used := firstParam(c.Params, names)
+		for _, name := range names {
+			if _, has := c.Params[name]; !has || name == used {
+				continue
+			}
+			warn(WarnIneffective, name, "%s has no effect, %s is used", name, used)
+		}
+	}
+	for _, names := range [][]string{passwordParams, passphraseParams, totpParams} {
+		for _, name := range names {
+			if _, has := c.Params[name]; has && isSecretCommand(name) {
+				warn(WarnSecretCommand, name, "%s runs a shell command from the address, it requires dial.WithSecretCommands", name)
+			}
+		}
+	}
+	if used := firstParam(c.Params, passwordParams); used != "" && c.Password != nil {
+		warn(WarnIneffective, used, "%s has no effect, password from the address is used", used)
+	}
+	if c.Net == "unix" && looksLikeHostPort(c.Addr) {
+		warn(WarnHostAsPath, "", "%s is dialed as unix socket path, use tcp://%s for tcp", c.Addr, strings.TrimPrefix(c.Addr, "/"))
+	}
+
+	names := make([]string, 0, len(c.Params))
+	for name := range c.Params {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	seen := make(map[string]string)
+	for _, name := range names {
+		vals := c.Params[name]
+		idx := slices.IndexFunc(knownParams, func(p knownParam) bool {
+			return strings.EqualFold(p.name, name)
+		})
+		if idx < 0 {
+			warn(WarnUnknownParam, name, "unknown param %s is ignored", name)
+			continue
+		}
+		param := knownParams[idx]
+		if param.name != name {
+			if param.caseSensitive {
+				warn(WarnParamCase, name, "param %s is ignored, use %s", name, param.name)
+				continue
+			}
+			warn(WarnParamCase, name, "param %s is written as %s", param.name, name)
+		}
+		if prev, has := seen[param.name]; has {
+			warn(WarnDuplicatedParam, name, "param %s is set as %s and %s, one is ignored", param.name, prev, name)
+		}
+		seen[param.name] = name
+		if len(vals) > 1 && !param.multiple {
+			warn(WarnDuplicatedParam, name, "multiple values for %s, param is ignored", name)
+			continue
+		}
+		for _, val := range vals {
+			if err := validateParam(param.name, val); err != nil {
+				warn(WarnInvalidValue, name, "invalid value for %s, param is ignored: %s", name, err)
+			}
+		}
+	}
+
+	if _, has := seen["ServerAliveInterval"]; !has {
+		for _, name := range []string{"ServerAliveCountMax", "ServerAliveTimeout", "ServerAliveLagMax"} {
+			if _, has := seen[name]; has {
+				warn(WarnIneffective, name, "%s has no effect without ServerAliveInterval", name)
+			}
+		}
+	}
+	return warnings
+}
+
+func validateParam(name, val string) error {
+	switch name {
+	case "ServerAliveInterval", "ServerAliveCountMax", "ServerAliveTimeout", "ServerAliveLagMax":
+		_, err := kaParse(name, []string{val}, 1)
+		return errors.Unwrap(err)
+	case "ConnMux":
+		_, err := strconv.ParseBool(val)
+		return err
+	case "Net":
+		if !validNet(val) {
+			return ErrInvalidNet
+		}
+	case "IdentityFile", "UserKnownHostsFile", "IdentityAgent":
+		if strings.TrimSpace(val) == "" {
+			return errors.New("empty path")
+		}
+	case "SecretTTL":
+		_, err := parseSecretTTL(map[string][]string{name: {val}})
+		return errors.Unwrap(err)
+	case "PreferredAuthentications":
+		_, err := authOrder(map[string][]string{name: {val}})
+		return err
+	case "AgentKeys":
+		_, err := parseAgentKeys(map[string][]string{name: {val}})
+		return err
+	case "ForwardAgent":
+		_, err := parseForwardAgent(map[string][]string{name: {val}})
+		return errors.Unwrap(err)
+	case "ControlPath":
+		_, err := Config{Params: url.Values{name: {val}}}.controlPath()
+		return errors.Unwrap(err)
+	case "ProxyJump":
+		_, err := Config{Params: url.Values{name: {val}}}.proxyJump()
+		return err
+	}
+	return nil
+}
+
+// firstParam returns the first of names, which is set in params
+func firstParam(params map[string][]string, names []string) string {
+	for _, name := range names {
+		if _, has := params[name]; has {
+			return name
+		}
+	}
+	return ""
+}
+
+// looksLikeHostPort checks for /host:port, which is a unix path for getAddrNet
+func looksLikeHostPort(path string) bool {
+	path = strings.TrimPrefix(path, "/")
+	if strings.ContainsAny(path, "/\\") {
+		return false
+	}
+	host, port, found := strings.Cut(path, ":")
+	if !found || host == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(port, 10, 16)
+	return err == nil
+}
+
+func (e Explanation) String() string {
+	var b strings.Builder
+	password := "no"
+	if e.HasPassword {
+		password = "yes"
+	}
+	_, _ = fmt.Fprintf(&b, "ssh:        %s@%s (password: %s)\n", e.Username, e.SSHAddr, password)
+	if e.Net != "" {
+		_, _ = fmt.Fprintf(&b, "target:     %s %s\n", e.Net, e.Addr)
+	} else {
+		_, _ = fmt.Fprintf(&b, "target:     -\n")
+	}
+	_, _ = fmt.Fprintf(&b, "conn mux:   %t\n", e.ConnMux)
+	if e.KeepAlive {
+		_, _ = fmt.Fprintf(&b, "keep alive: interval %s, count max %d, timeout %s, lag max %s\n",
+			e.ServerAliveInterval, e.ServerAliveCountMax, e.ServerAliveTimeout, e.ServerAliveLagMax)
+	} else {
+		_, _ = fmt.Fprintf(&b, "keep alive: off\n")
+	}
+	_, _ = fmt.Fprintf(&b, "auth:       %s\n", strings.Join(e.AuthOrder, ", "))
+	if len(e.IdentityFiles) > 0 {
+		_, _ = fmt.Fprintf(&b, "identity:   %s\n", strings.Join(e.IdentityFiles, ", "))
+	}
+	if len(e.KnownHostsFiles) > 0 {
+		_, _ = fmt.Fprintf(&b, "hosts file: %s\n", strings.Join(e.KnownHostsFiles, ", "))
+	}
+	if e.ProxyJump != "" {
+		_, _ = fmt.Fprintf(&b, "proxy jump: %s\n", e.ProxyJump)
+	}
+	if e.ControlPath != "" {
+		_, _ = fmt.Fprintf(&b, "control:    %s\n", e.ControlPath)
+	}
+	for _, w := range e.Warnings {
+		_, _ = fmt.Fprintf(&b, "warning:    %s\n", w)
+	}
+	return b.String()
+}
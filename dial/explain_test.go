@@ -0,0 +1,121 @@
+package dial
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConfig_Lint(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+		want []WarningCode
+	}{
+		{
+			name: "valid",
+			addr: "user@host/127.0.0.1:3306?ServerAliveInterval=10&ConnMux=false",
+		},
+		{
+			name: "missing",
+			addr: "host",
+			want: []WarningCode{WarnMissingField, WarnMissingField},
+		},
+		{
+			name: "password",
+			addr: "user:pass@host/my.sock",
+			want: []WarningCode{WarnPasswordInAddr},
+		},
+		{
+			name: "host as path",
+			addr: "user@host/db.internal:3306",
+			want: []WarningCode{WarnHostAsPath},
+		},
+		{
+			name: "unknown",
+			addr: "user@host/my.sock?ServerAliveIntervall=10",
+			want: []WarningCode{WarnUnknownParam},
+		},
+		{
+			name: "duplicated",
+			addr: "user@host/my.sock?ServerAliveInterval=10&ServerAliveInterval=20",
+			want: []WarningCode{WarnDuplicatedParam},
+		},
+		{
+			name: "duplicated case",
+			addr: "user@host/my.sock?ServerAliveInterval=10&serveraliveinterval=20",
+			want: []WarningCode{WarnDuplicatedParam, WarnParamCase},
+		},
+		{
+			name: "case sensitive",
+			addr: "user@host/my.sock?connmux=false",
+			want: []WarningCode{WarnParamCase},
+		},
+		{
+			name: "invalid",
+			addr: "user@host/my.sock?ServerAliveInterval=10s&ConnMux=nope",
+			want: []WarningCode{WarnInvalidValue, WarnInvalidValue},
+		},
+		{
+			name: "ineffective",
+			addr: "user@host/my.sock?ServerAliveCountMax=10",
+			want: []WarningCode{WarnIneffective},
+		},
+		{
+			name: "password sources",
+			addr: "user:pass@host/my.sock?PasswordFile=/run/pass&PasswordEnv=PASS&SecretTTL=10",
+			want: []WarningCode{WarnPasswordInAddr, WarnIneffective, WarnIneffective},
+		},
+		{
+			name: "secret command",
+			addr: "user@host/my.sock?TOTPSecretCommand=pass+show+totp",
+			want: []WarningCode{WarnSecretCommand},
+		},
+		{
+			name: "invalid secret ttl",
+			addr: "user@host/my.sock?PassphraseFile=/run/pass&SecretTTL=1m",
+			want: []WarningCode{WarnInvalidValue},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseAddr(tt.addr)
+			if err != nil {
+				t.Fatalf("ParseAddr() error = %v", err)
+			}
+			var got []WarningCode
+			for _, w := range config.Lint() {
+				got = append(got, w.Code)
+			}
+			slices.Sort(got)
+			slices.Sort(tt.want)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Lint() = %v, want %v", config.Lint(), tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Explain(t *testing.T) {
+	config, err := ParseAddr("user@host/my.sock?ServerAliveInterval=10&ConnMux=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := config.Explain()
+	want := Explanation{
+		Username:            "user",
+		SSHAddr:             "host:22",
+		Net:                 "unix",
+		Addr:                "/my.sock",
+		ConnMux:             false,
+		KeepAlive:           true,
+		ServerAliveInterval: 10 * time.Second,
+		ServerAliveCountMax: serverAliveCountMax,
+		ServerAliveTimeout:  10 * time.Second,
+		ServerAliveLagMax:   serverAliveLagMax,
+		AuthOrder:           []string{"password", "keyboard-interactive", "publickey"},
+	}
+	if got.String() != want.String() || len(got.Warnings) != 0 {
+		t.Errorf("Explain() = %+v, want %+v", got, want)
+	}
+}
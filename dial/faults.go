@@ -0,0 +1,239 @@
+package dial
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Faults injects network failures into ssh client ↔ server TCP connections of a Dialer.
+// It is intended to test keep alive and pool eviction, see WithFaults.
+// Settings can be changed at any time and are safe for concurrent use.
+type Faults struct {
+	mu              sync.Mutex
+	latency         time.Duration
+	bandwidth       int
+	blackHole       bool
+	resume          chan struct{}
+	closeAfterBytes int64
+	closeAfter      time.Duration
+}
+
+// WithFaults wraps every ssh client ↔ server connection of the Dialer with f.
+func WithFaults(f *Faults) Option {
+	return func(d *Dialer) {
+		d.faults = f
+	}
+}
+
+func NewFaults() *Faults {
+	return &Faults{}
+}
+
+// SetLatency delays every read and write. Zero disables.
+func (f *Faults) SetLatency(latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = latency
+}
+
+// SetBandwidth throttles reads and writes of every connection to bytesPerSecond in each direction. Zero disables.
+func (f *Faults) SetBandwidth(bytesPerSecond int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bandwidth = bytesPerSecond
+}
+
+// SetBlackHole stalls reads, as if the server has stopped responding. Writes still succeed.
+// Data, received while the black hole is on, is delivered after it is turned off.
+func (f *Faults) SetBlackHole(on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.blackHole == on {
+		return
+	}
+	f.blackHole = on
+	if on {
+		f.resume = make(chan struct{})
+	} else {
+		close(f.resume)
+	}
+}
+
+// CloseAfterBytes abruptly closes every connection, which has read and written more than n bytes in total.
+// It affects existing connections too. Zero disables.
+func (f *Faults) CloseAfterBytes(n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closeAfterBytes = n
+}
+
+// CloseAfter abruptly closes every new connection after d. Zero disables.
+func (f *Faults) CloseAfter(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closeAfter = d
+}
+
+var ErrFaultClosed = errors.New("connection closed by fault injection")
+
+func (f *Faults) wrap(conn net.Conn) net.Conn {
+	c := &faultConn{
+		Conn:   conn,
+		faults: f,
+		closed: make(chan struct{}),
+	}
+	f.mu.Lock()
+	closeAfter := f.closeAfter
+	f.mu.Unlock()
+	if closeAfter > 0 {
+		go c.faultAfter(closeAfter)
+	}
+	return c
+}
+
+type faultConn struct {
+	net.Conn
+	faults      *Faults
+	transferred atomic.Int64
+	faulted     atomic.Bool
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+type faultSettings struct {
+	latency         time.Duration
+	bandwidth       int
+	resume          chan struct{}
+	closeAfterBytes int64
+}
+
+func (f *Faults) settings() faultSettings {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := faultSettings{
+		latency:         f.latency,
+		bandwidth:       f.bandwidth,
+		closeAfterBytes: f.closeAfterBytes,
+	}
+	if f.blackHole {
+		res.resume = f.resume
+	}
+	return res
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	if c.faulted.Load() {
+		return 0, ErrFaultClosed
+	}
+	b = c.limit(b)
+	n, err := c.Conn.Read(b)
+
+	for {
+		s := c.faults.settings()
+		if s.resume == nil {
+			break
+		}
+		select {
+		case <-s.resume:
+		case <-c.closed:
+			return 0, net.ErrClosed
+		}
+	}
+	if c.faulted.Load() {
+		return 0, ErrFaultClosed
+	}
+
+	c.delay(n)
+	if c.count(n) {
+		return 0, ErrFaultClosed
+	}
+	return n, err
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		if c.faulted.Load() {
+			return written, ErrFaultClosed
+		}
+		chunk := c.limit(b)
+		c.delay(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if c.count(n) {
+			return written, ErrFaultClosed
+		}
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (c *faultConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
+
+// fault closes the connection abruptly
+func (c *faultConn) fault() {
+	c.faulted.Store(true)
+	_ = c.Close()
+}
+
+func (c *faultConn) faultAfter(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		c.fault()
+	case <-c.closed:
+	}
+}
+
+// limit cuts b to transfer 100ms of data under bandwidth limit
+func (c *faultConn) limit(b []byte) []byte {
+	bandwidth := c.faults.settings().bandwidth
+	if bandwidth <= 0 {
+		return b
+	}
+	chunk := max(bandwidth/10, 1)
+	if len(b) > chunk {
+		return b[:chunk]
+	}
+	return b
+}
+
+func (c *faultConn) delay(n int) {
+	s := c.faults.settings()
+	d := s.latency
+	if s.bandwidth > 0 && n > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(s.bandwidth)
+	}
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-c.closed:
+	}
+}
+
+// count returns true, if the connection was closed because of CloseAfterBytes
+func (c *faultConn) count(n int) bool {
+	total := c.transferred.Add(int64(n))
+	limit := c.faults.settings().closeAfterBytes
+	if limit > 0 && total > limit {
+		c.fault()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package dial
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFaultsCloseAfterBytes(t *testing.T) {
+	f := NewFaults()
+	f.CloseAfterBytes(5)
+	client, server := faultPipe(t, f)
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := client.Write([]byte("!")); !errors.Is(err, ErrFaultClosed) {
+		t.Fatalf("Write() error = %v, want %v", err, ErrFaultClosed)
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Read() after fault: expected error")
+	}
+}
+
+func TestFaultsBlackHole(t *testing.T) {
+	f := NewFaults()
+	client, server := faultPipe(t, f)
+	f.SetBlackHole(true)
+
+	read := make(chan string)
+	go func() {
+		buf := make([]byte, 5)
+		n, _ := io.ReadFull(client, buf)
+		read <- string(buf[:n])
+	}()
+	go func() { _, _ = server.Write([]byte("hello")) }()
+
+	select {
+	case got := <-read:
+		t.Fatalf("Read() = %q through black hole", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	f.SetBlackHole(false)
+	select {
+	case got := <-read:
+		if got != "hello" {
+			t.Errorf("Read() = %q, want %q", got, "hello")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Read() is not resumed")
+	}
+}
+
+func TestFaultsBlackHoleClose(t *testing.T) {
+	f := NewFaults()
+	client, server := faultPipe(t, f)
+	f.SetBlackHole(true)
+	go func() { _, _ = server.Write([]byte("hello")) }()
+
+	readErr := make(chan error)
+	go func() {
+		_, err := client.Read(make([]byte, 5))
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_ = client.Close()
+
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Errorf("Read() expected error on closed conn")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Read() is not interrupted by Close")
+	}
+}
+
+func TestFaultsLatency(t *testing.T) {
+	const latency = 30 * time.Millisecond
+	f := NewFaults()
+	f.SetLatency(latency)
+	client, server := faultPipe(t, f)
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	start := time.Now()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if took := time.Since(start); took < latency {
+		t.Errorf("Write() took %v, want >= %v", took, latency)
+	}
+}
+
+func TestFaultsBandwidth(t *testing.T) {
+	const (
+		bandwidth = 1000
+		size      = 200
+		want      = size * time.Second / bandwidth
+	)
+	f := NewFaults()
+	f.SetBandwidth(bandwidth)
+	client, server := faultPipe(t, f)
+	go func() {
+		// net.Pipe is unbuffered, so data is echoed after it is read in full
+		buf := make([]byte, size)
+		for {
+			if _, err := io.ReadFull(server, buf); err != nil {
+				return
+			}
+			if _, err := server.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+	data := make([]byte, size)
+
+	start := time.Now()
+	if n, err := client.Write(data); err != nil || n != size {
+		t.Fatalf("Write() = %d, %v, want %d", n, err, size)
+	}
+	if took := time.Since(start); took < want {
+		t.Errorf("Write() took %v, want >= %v", took, want)
+	}
+
+	start = time.Now()
+	buf := make([]byte, size)
+	if n, err := client.Read(buf); err != nil || n > bandwidth/10 {
+		t.Fatalf("Read() = %d, %v, want at most %d bytes", n, err, bandwidth/10)
+	}
+	if _, err := io.ReadFull(client, buf[bandwidth/10:]); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if took := time.Since(start); took < want {
+		t.Errorf("Read() took %v, want >= %v", took, want)
+	}
+
+	// zero disables the limit
+	f.SetBandwidth(0)
+	start = time.Now()
+	if _, err := client.Write(data); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if took := time.Since(start); took >= want/2 {
+		t.Errorf("Write() and Read() without limit took %v, want < %v", took, want/2)
+	}
+}
+
+func TestFaultsCloseAfter(t *testing.T) {
+	f := NewFaults()
+	f.CloseAfter(10 * time.Millisecond)
+	client, _ := faultPipe(t, f)
+
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Read() expected error after CloseAfter")
+	}
+}
+
+func faultPipe(t *testing.T, f *Faults) (net.Conn, net.Conn) {
+	client, server := net.Pipe()
+	client = f.wrap(client)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
@@ -12,26 +12,39 @@ import (
 )
 
 func makeKeepAliveConfig(values url.Values) keepAliveConfig {
+	result, errs := parseKeepAliveConfig(values)
+	for _, err := range errs {
+		logger().Error("mytunnel/dial: skipping keep alive param", "err", err.Error())
+	}
+	return result
+}
+
+func parseKeepAliveConfig(values url.Values) (keepAliveConfig, []error) {
 	var result = keepAliveConfig{
 		serverAliveCountMax: -1,
 		serverAliveInterval: -1,
 		serverAliveTimeout:  -1,
 		serverAliveLagMax:   -1,
 	}
+	var errs []error
 	for k, v := range values {
+		var err error
 		switch {
 		case strings.EqualFold(k, "ServerAliveInterval"):
-			result.serverAliveInterval = kaParse(k, v, time.Second)
+			result.serverAliveInterval, err = kaParse(k, v, time.Second)
 		case strings.EqualFold(k, "ServerAliveCountMax"):
-			result.serverAliveCountMax = kaParse(k, v, 1)
+			result.serverAliveCountMax, err = kaParse(k, v, 1)
 		case strings.EqualFold(k, "ServerAliveTimeout"):
-			result.serverAliveTimeout = kaParse(k, v, time.Second)
+			result.serverAliveTimeout, err = kaParse(k, v, time.Second)
 		case strings.EqualFold(k, "ServerAliveLagMax"):
-			result.serverAliveLagMax = kaParse(k, v, time.Second)
+			result.serverAliveLagMax, err = kaParse(k, v, time.Second)
+		}
+		if err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if !result.keepAlive() {
-		return keepAliveConfig{}
+		return keepAliveConfig{}, errs
 	}
 	if result.serverAliveTimeout <= 0 {
 		result.serverAliveTimeout = result.serverAliveInterval
@@ -42,34 +55,32 @@ func makeKeepAliveConfig(values url.Values) keepAliveConfig {
 	if result.serverAliveLagMax < 0 {
 		result.serverAliveLagMax = serverAliveLagMax
 	}
-	return result
+	return result, errs
 }
 
 type intType interface {
 	~int64 | ~int
 }
 
-func kaParse[Int intType](key string, vals []string, units Int) Int {
+func kaParse[Int intType](key string, vals []string, units Int) (Int, error) {
 	if len(vals) == 0 {
-		return -1
+		return -1, nil
 	}
 	if len(vals) > 1 {
-		logger().Error(fmt.Sprintf("mytunnel/dial: multiple values for %s, skipping keep alive param", key))
-		return -1
+		return -1, fmt.Errorf("multiple values for %s", key)
 	}
 	res, err := strconv.ParseUint(vals[0], 10, 64)
 	if err == nil && res > math.MaxInt {
 		err = fmt.Errorf("value %s > MaxInt", vals[0])
 	}
 	if err != nil {
-		logger().Error(fmt.Sprintf("mytunnel/dial: invalid value for %s, skipping keep alive param", key), "err", err.Error())
-		return -1
+		return -1, fmt.Errorf("invalid value for %s: %w", key, err)
 	}
-	return Int(res) * units
+	return Int(res) * units, nil
 }
 
-func keepAlive(cli sshClient, config keepAliveConfig) {
-	go keepAliveLoop(cli, config)
+func keepAlive(cli sshClient, config keepAliveConfig, clock Clock) {
+	go keepAliveLoop(cli, config, clock)
 }
 
 type keepAliveConfig struct {
@@ -88,8 +99,8 @@ const (
 	serverAliveLagMax   = 2 * time.Second
 )
 
-func keepAliveLoop(cli sshClient, config keepAliveConfig) {
-	ticker := time.NewTicker(config.serverAliveInterval)
+func keepAliveLoop(cli sshClient, config keepAliveConfig, clock Clock) {
+	ticker := clock.NewTicker(config.serverAliveInterval)
 	defer ticker.Stop()
 	done := make(chan struct{})
 	defer close(done)
@@ -118,9 +129,9 @@ func keepAliveLoop(cli sshClient, config keepAliveConfig) {
 			// should be already closed, but make sure
 			_ = cli.Close()
 			return
-		case <-ticker.C:
-			start := time.Now()
-			err := sendKeepAliveRequest(cli, keepAliveReq, keepAliveResp, config.serverAliveTimeout, config.serverAliveLagMax)
+		case <-ticker.C():
+			start := clock.Now()
+			err := sendKeepAliveRequest(cli, clock, keepAliveReq, keepAliveResp, config.serverAliveTimeout, config.serverAliveLagMax)
 			if err == nil {
 				ticker.Reset(config.serverAliveInterval)
 				continue
@@ -139,7 +150,7 @@ func keepAliveLoop(cli sshClient, config keepAliveConfig) {
 			}
 
 			_ = cli.Close()
-			logger().Debug("mytunnel/dial: keepAlive", "err", err.Error(), "took", time.Since(start))
+			logger().Debug("mytunnel/dial: keepAlive", "err", err.Error(), "took", clock.Now().Sub(start))
 			return
 		case <-cli.successfulRead():
 			ticker.Reset(config.serverAliveInterval)
@@ -167,7 +178,7 @@ var errKeepAliveTimeout = errors.New("keep alive timeout")
 
 var logEveryKeepAliveRequest = false
 
-func sendKeepAliveRequest(client sshClient, req chan<- struct{}, resp <-chan error, timeout, lag time.Duration) (err error) {
+func sendKeepAliveRequest(client sshClient, clock Clock, req chan<- struct{}, resp <-chan error, timeout, lag time.Duration) (err error) {
 	select {
 	case err := <-resp:
 		// maybe there is a result of a previous timed-out request
@@ -178,19 +189,19 @@ func sendKeepAliveRequest(client sshClient, req chan<- struct{}, resp <-chan err
 	}
 
 	if logEveryKeepAliveRequest {
-		logStart := time.Now()
+		logStart := clock.Now()
 		logger().Debug("mytunnel/dial: stating keep alive", "client", client, "timeout", timeout, "lag", lag)
 		defer func() {
-			logger().Debug("mytunnel/dial: finished keep alive", "client", client, "took", time.Since(logStart), "timeout", timeout, "lag", lag, "err", err)
+			logger().Debug("mytunnel/dial: finished keep alive", "client", client, "took", clock.Now().Sub(logStart), "timeout", timeout, "lag", lag, "err", err)
 		}()
 	}
 
-	start := time.Now()
-	timer := time.NewTimer(timeout)
+	start := clock.Now()
+	timer := clock.NewTimer(timeout)
 	defer timer.Stop()
 
 	handleTimeout := func() error {
-		took := time.Since(start)
+		took := clock.Now().Sub(start)
 		if took >= timeout+lag {
 			logger().Debug("mytunnel/dial: sendKeepAliveRequest: seems to be paused by debugger (or some other lag), skipping timeout", "took", took, "timeout", timeout, "lag", lag)
 			return nil
@@ -201,7 +212,7 @@ func sendKeepAliveRequest(client sshClient, req chan<- struct{}, resp <-chan err
 	select {
 	case req <- struct{}{}:
 		select {
-		case <-timer.C:
+		case <-timer.C():
 			return handleTimeout()
 		case err := <-resp:
 			return err
@@ -209,7 +220,7 @@ func sendKeepAliveRequest(client sshClient, req chan<- struct{}, resp <-chan err
 			// in-flight request will be consumed at the beginning of [sendKeepAliveRequest]
 			return nil
 		}
-	case <-timer.C:
+	case <-timer.C():
 		return handleTimeout()
 	case err := <-resp:
 		// maybe there is a result of a previous timed-out out request
@@ -0,0 +1,287 @@
+package dial
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeepAliveCountMax(t *testing.T) {
+	clock := newFakeClock()
+	cli := newKeepAliveClient()
+	config := keepAliveConfig{
+		serverAliveCountMax: 2,
+		serverAliveInterval: 10 * time.Second,
+		serverAliveTimeout:  10 * time.Second,
+		serverAliveLagMax:   2 * time.Second,
+	}
+	keepAlive(cli, config, clock)
+
+	// ticker
+	clock.waitActive(t, 1)
+	clock.Advance(config.serverAliveInterval)
+
+	// the server never answers: count max timeouts are tolerated
+	for range config.serverAliveCountMax {
+		clock.waitActive(t, 2)
+		clock.Advance(config.serverAliveTimeout)
+		cli.assertOpen(t)
+	}
+
+	clock.waitActive(t, 2)
+	clock.Advance(config.serverAliveTimeout)
+	cli.assertClosed(t)
+}
+
+func TestKeepAliveLagMax(t *testing.T) {
+	clock := newFakeClock()
+	cli := newKeepAliveClient()
+	config := keepAliveConfig{
+		serverAliveCountMax: 0,
+		serverAliveInterval: 10 * time.Second,
+		serverAliveTimeout:  10 * time.Second,
+		serverAliveLagMax:   2 * time.Second,
+	}
+	keepAlive(cli, config, clock)
+
+	clock.waitActive(t, 1)
+	clock.Advance(config.serverAliveInterval)
+
+	// paused by debugger
+	clock.waitActive(t, 2)
+	clock.Advance(config.serverAliveTimeout + config.serverAliveLagMax)
+	cli.assertOpen(t)
+
+	// ticker is reset after skipped timeout
+	clock.waitActive(t, 1)
+	clock.Advance(config.serverAliveInterval)
+
+	// real timeout
+	clock.waitActive(t, 2)
+	clock.Advance(config.serverAliveTimeout)
+	cli.assertClosed(t)
+}
+
+func TestKeepAliveReplies(t *testing.T) {
+	clock := newFakeClock()
+	cli := newKeepAliveClient()
+	cli.reply = true
+	config := keepAliveConfig{
+		serverAliveCountMax: 0,
+		serverAliveInterval: 10 * time.Second,
+		serverAliveTimeout:  time.Second,
+		serverAliveLagMax:   time.Second,
+	}
+	keepAlive(cli, config, clock)
+
+	for range 5 {
+		clock.waitActive(t, 1)
+		clock.Advance(config.serverAliveInterval)
+		cli.waitRequest(t)
+	}
+	cli.assertOpen(t)
+
+	_ = cli.Close()
+}
+
+type keepAliveClient struct {
+	reply    bool
+	requests chan struct{}
+	closed   chan struct{}
+	close    sync.Once
+}
+
+func newKeepAliveClient() *keepAliveClient {
+	return &keepAliveClient{
+		requests: make(chan struct{}, 100),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (c *keepAliveClient) DialContext(context.Context, string, string) (net.Conn, error) {
+	return nil, io.EOF
+}
+
+func (c *keepAliveClient) NewSession() (*ssh.Session, error) {
+	return nil, io.EOF
+}
+
+func (c *keepAliveClient) SendRequest(string, bool, []byte) (bool, []byte, error) {
+	c.requests <- struct{}{}
+	if c.reply {
+		return true, nil, nil
+	}
+	<-c.closed
+	return false, nil, io.EOF
+}
+
+func (c *keepAliveClient) Close() error {
+	c.close.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *keepAliveClient) Wait() error {
+	<-c.closed
+	return nil
+}
+
+func (c *keepAliveClient) successfulRead() <-chan struct{} {
+	return nil
+}
+
+func (c *keepAliveClient) waitRequest(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.requests:
+	case <-time.After(time.Second):
+		t.Fatalf("keep alive request is not sent")
+	}
+}
+
+func (c *keepAliveClient) assertOpen(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.closed:
+		t.Fatalf("client is closed")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func (c *keepAliveClient) assertClosed(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.closed:
+	case <-time.After(time.Second):
+		t.Fatalf("client is not closed")
+	}
+}
+
+// fakeClock fires timers and tickers only on Advance
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration // tickers only
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		timers: make(map[*fakeTimer]struct{}),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, 0)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.add(d, d)}
+}
+
+func (c *fakeClock) add(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{
+		clock:    c,
+		c:        make(chan time.Time, 1),
+		deadline: c.now.Add(d),
+		period:   period,
+	}
+	c.timers[t] = struct{}{}
+	return t
+}
+
+// Advance moves time forward and fires expired timers and tickers
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if t.deadline.After(c.now) {
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		if t.period > 0 {
+			for !t.deadline.After(c.now) {
+				t.deadline = t.deadline.Add(t.period)
+			}
+		} else {
+			delete(c.timers, t)
+		}
+	}
+}
+
+// waitActive waits until n timers and tickers are waiting for Advance
+func (c *fakeClock) waitActive(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		active := len(c.timers)
+		c.mu.Unlock()
+		if active == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d active timers are expected", n)
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	t.drain()
+	return active
+}
+
+// drain mimics go1.23 timers: no stale values after Stop or Reset
+func (t *fakeTimer) drain() {
+	select {
+	case <-t.c:
+	default:
+	}
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.deadline = t.clock.now.Add(d)
+	t.period = d
+	t.clock.timers[t.fakeTimer] = struct{}{}
+	t.drain()
+}
@@ -0,0 +1,205 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// MemoryBackend replaces ssh clients of a Dialer with in-memory ones, see WithMemoryBackend.
+// Each connection is a net.Pipe, served by a handler registered for the target address.
+// Pooling, keep alive and eviction work as usual, so it can back a real driver in tests.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	handlers map[string]func(conn net.Conn)
+	onDial   func(network, addr string) error
+
+	created atomic.Uint64
+	closed  atomic.Uint64
+}
+
+// WithMemoryBackend makes the Dialer use b instead of ssh.
+func WithMemoryBackend(b *MemoryBackend) Option {
+	return func(d *Dialer) {
+		d.backend = b
+	}
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		handlers: make(map[string]func(conn net.Conn)),
+	}
+}
+
+// Handle registers handler for the target address, e.g. "/tmp/mysql.sock" or "127.0.0.1:3306".
+// handler is called in a new goroutine with the server side of each connection.
+// Dials to addresses without handlers are rejected, as if the remote side refused the connection.
+func (b *MemoryBackend) Handle(addr string, handler func(conn net.Conn)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[addr] = handler
+}
+
+// OnDial sets a hook, called before every dial. Non-nil error fails the dial,
+// as if the ssh transport has broken, so the client is evicted from the pool.
+func (b *MemoryBackend) OnDial(hook func(network, addr string) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onDial = hook
+}
+
+// Clients returns the number of created and closed clients.
+func (b *MemoryBackend) Clients() (created, closed uint64) {
+	return b.created.Load(), b.closed.Load()
+}
+
+func (b *MemoryBackend) newClient() *memoryClient {
+	b.created.Add(1)
+	return &memoryClient{
+		backend: b,
+		done:    make(chan struct{}),
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+func (b *MemoryBackend) route(network, addr string) (func(conn net.Conn), error) {
+	b.mu.Lock()
+	onDial := b.onDial
+	handler, has := b.handlers[addr]
+	b.mu.Unlock()
+
+	if onDial != nil {
+		if err := onDial(network, addr); err != nil {
+			return nil, err
+		}
+	}
+	if !has {
+		return nil, &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "no handler for " + addr}
+	}
+	return handler, nil
+}
+
+type memoryClient struct {
+	backend *MemoryBackend
+	done    chan struct{}
+	close   sync.Once
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (c *memoryClient) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	handler, err := c.backend.route(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed() {
+		return nil, io.EOF
+	}
+	client, server := net.Pipe()
+	var (
+		local  = memoryAddr{network: network, addr: "memory"}
+		remote = memoryAddr{network: network, addr: addr}
+	)
+	conn := &memoryConn{Conn: client, local: local, remote: remote}
+	c.conns[conn] = struct{}{}
+	conn.onClose = func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.conns, conn)
+	}
+	go handler(&memoryConn{Conn: server, local: remote, remote: local})
+	return conn, nil
+}
+
+var errMemorySession = errors.New("sessions are not supported by memory backend")
+
+func (c *memoryClient) NewSession() (*ssh.Session, error) {
+	return nil, errMemorySession
+}
+
+func (c *memoryClient) SendRequest(string, bool, []byte) (bool, []byte, error) {
+	if c.isClosed() {
+		return false, nil, io.EOF
+	}
+	return true, nil, nil
+}
+
+func (c *memoryClient) Close() error {
+	c.close.Do(func() {
+		c.mu.Lock()
+		close(c.done)
+		conns := c.conns
+		c.conns = nil
+		c.mu.Unlock()
+
+		// like ssh channels, connections are closed with the client
+		for conn := range conns {
+			_ = conn.Close()
+		}
+		c.backend.closed.Add(1)
+	})
+	return nil
+}
+
+func (c *memoryClient) Wait() error {
+	<-c.done
+	return nil
+}
+
+func (c *memoryClient) successfulRead() <-chan struct{} {
+	return nil
+}
+
+func (c *memoryClient) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+type memoryConn struct {
+	net.Conn
+	local, remote net.Addr
+	onClose       func()
+}
+
+func (c *memoryConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *memoryConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *memoryConn) Close() error {
+	if c.onClose != nil {
+		c.onClose()
+	}
+	return c.Conn.Close()
+}
+
+type memoryAddr struct {
+	network, addr string
+}
+
+func (a memoryAddr) Network() string {
+	return a.network
+}
+
+func (a memoryAddr) String() string {
+	return a.addr
+}
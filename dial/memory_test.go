@@ -0,0 +1,121 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.Handle("/my.sock", func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+		_, _ = io.Copy(conn, conn)
+	})
+	dialer := NewDialer(WithMemoryBackend(backend))
+	ctx := context.Background()
+
+	conn, err := dialer.DialContext(ctx, "user@host/my.sock")
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	assertEcho(t, conn)
+
+	if got := conn.RemoteAddr(); got.Network() != "unix" || got.String() != "/my.sock" {
+		t.Errorf("RemoteAddr() = %s %s", got.Network(), got.String())
+	}
+
+	if err = conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		t.Fatalf("SetReadDeadline() error = %v", err)
+	}
+	if _, err = conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	// rejected channel keeps the client in the pool
+	_, err = dialer.DialContext(ctx, "user@host/other.sock")
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) {
+		t.Errorf("DialContext() error = %v, want OpenChannelError", err)
+	}
+	assertEcho(t, conn)
+	if created, closed := backend.Clients(); created != 1 || closed != 0 {
+		t.Errorf("Clients() = %d, %d, want 1, 0", created, closed)
+	}
+	// the client is closed with the last connection
+	_ = conn.Close()
+	if created, closed := backend.Clients(); created != 1 || closed != 1 {
+		t.Errorf("Clients() after Close() = %d, %d, want 1, 1", created, closed)
+	}
+}
+
+func TestMemoryBackendBrokenClient(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.Handle("/my.sock", func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	dialer := NewDialer(WithMemoryBackend(backend))
+	ctx := context.Background()
+
+	conn, err := dialer.DialContext(ctx, "user@host/my.sock")
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	broken := true
+	backend.OnDial(func(string, string) error {
+		if broken {
+			broken = false
+			return io.EOF
+		}
+		return nil
+	})
+
+	// broken client is evicted with its connections, the dial is retried with a new one
+	conn2, err := dialer.DialContext(ctx, "user@host/my.sock")
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer func() { _ = conn2.Close() }()
+
+	if _, err = conn.Write([]byte("hello")); err == nil {
+		t.Errorf("Write() to connection of evicted client: expected error")
+	}
+	if created, closed := backend.Clients(); created != 2 || closed != 1 {
+		t.Errorf("Clients() = %d, %d, want 2, 1", created, closed)
+	}
+}
+
+func TestMemoryBackendRejectedChannel(t *testing.T) {
+	backend := NewMemoryBackend()
+	dialer := NewDialer(WithMemoryBackend(backend))
+	ctx := context.Background()
+
+	// rejected channel releases the client, which is closed without other connections
+	_, err := dialer.DialContext(ctx, "user@host/other.sock")
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("DialContext() error = %v, want OpenChannelError", err)
+	}
+	if created, closed := backend.Clients(); created != 1 || closed != 1 {
+		t.Errorf("Clients() = %d, %d, want 1, 1", created, closed)
+	}
+
+	// the released client is not reused
+	_, err = dialer.DialContext(ctx, "user@host/other.sock")
+	if !errors.As(err, &openErr) {
+		t.Fatalf("DialContext() error = %v, want OpenChannelError", err)
+	}
+	if created, closed := backend.Clients(); created != 2 || closed != 2 {
+		t.Errorf("Clients() = %d, %d, want 2, 2", created, closed)
+	}
+}
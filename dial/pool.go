@@ -4,6 +4,8 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"log/slog"
+	"net/url"
 	"sync"
 	"sync/atomic"
 )
@@ -18,6 +20,8 @@ type (
 		Password  string
 		Addr      string
 		KeepAlive keepAliveConfig
+		// Options are encoded clientParams
+		Options string
 	}
 	clientPoolEntry struct {
 		done     chan struct{}
@@ -49,9 +53,47 @@ func (c Config) clientKey(config keepAliveConfig) clientKey {
 		Password:  passKey(c.Password),
 		Addr:      c.sshAddr(),
 		KeepAlive: config,
+		Options:   c.clientOptions(),
 	}
 }
 
+// clientParams affect the ssh client, so clients with different values are not shared
+var clientParams = []string{
+	"IdentityFile", "UserKnownHostsFile", "ProxyJump",
+	"PasswordFile", "PasswordEnv", "PasswordCommand",
+	"PassphraseFile", "PassphraseEnv", "PassphraseCommand",
+	"TOTPSecretFile", "TOTPSecretEnv", "TOTPSecretCommand",
+	"PreferredAuthentications", "AgentKeys", "IdentityAgent", "ForwardAgent",
+}
+
+func (c Config) clientOptions() string {
+	opts := make(url.Values)
+	for _, name := range clientParams {
+		if vals := c.Params[name]; len(vals) > 0 {
+			opts[name] = vals
+		}
+	}
+	return opts.Encode()
+}
+
+// LogValue implements slog.LogValuer, the password hash is not logged.
+func (k clientKey) LogValue() slog.Value {
+	password := "none"
+	switch k.Password {
+	case "-":
+	case "*":
+		password = "empty"
+	default:
+		password = "set"
+	}
+	return slog.GroupValue(
+		slog.String("user", k.Username),
+		slog.String("password", password),
+		slog.String("addr", k.Addr),
+		slog.Bool("keepAlive", k.KeepAlive.keepAlive()),
+	)
+}
+
 func (p *sshClientPool) acquire(ctx context.Context, key clientKey, ctor sshClientCtor) (*sshPooledTunnel, error) {
 	for {
 		p.mu.Lock()
@@ -83,6 +125,7 @@ func (p *sshClientPool) acquire(ctx context.Context, key clientKey, ctor sshClie
 		e.startAccess()
 		if err == nil {
 
+			logger().Debug("mytunnel/dial: pooled ssh client created", "key", key)
 			e.val = &sshPooledTunnel{
 				client: client,
 				pool:   p,
@@ -180,6 +223,7 @@ func (p *sshClientPool) tryRelease(value *sshPooledTunnel, force bool) bool {
 
 	e.removed = true
 	delete(p.m, value.key)
+	logger().Debug("mytunnel/dial: pooled ssh client removed", "key", value.key, "forced", force)
 	return true
 }
 
@@ -0,0 +1,266 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider returns a secret, like a password or a key passphrase.
+// It is called at handshake time, so rotated secrets are picked up without a restart.
+type SecretProvider interface {
+	Secret(ctx context.Context) (string, error)
+}
+
+// SecretFunc is an adapter to use ordinary functions as SecretProvider.
+type SecretFunc func(ctx context.Context) (string, error)
+
+func (f SecretFunc) Secret(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// SecretFile reads the secret from the file. A trailing newline is trimmed.
+func SecretFile(path string) SecretProvider {
+	return SecretFunc(func(ctx context.Context) (string, error) {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return trimNewline(string(buf)), nil
+	})
+}
+
+// SecretEnv reads the secret from the environment variable. Unset variable is an error, empty one is not.
+func SecretEnv(name string) SecretProvider {
+	return SecretFunc(func(ctx context.Context) (string, error) {
+		val, has := os.LookupEnv(name)
+		if !has {
+			return "", fmt.Errorf("env %s is not set", name)
+		}
+		return val, nil
+	})
+}
+
+// SecretCommand runs the command with the system shell and returns its stdout. A trailing newline is trimmed.
+func SecretCommand(command string) SecretProvider {
+	return SecretFunc(func(ctx context.Context) (string, error) {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		} else {
+			cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+		}
+		out, err := cmd.Output()
+		if err != nil {
+			// stderr is not included, it may contain the secret
+			return "", fmt.Errorf("command %q: %w", command, err)
+		}
+		return trimNewline(string(out)), nil
+	})
+}
+
+func trimNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
+
+// WithPasswordProvider sets the password for configs without a password and without Password* params.
+func WithPasswordProvider(p SecretProvider) Option {
+	return func(d *Dialer) {
+		d.passwordProvider = p
+	}
+}
+
+// WithPassphraseProvider sets the passphrase of encrypted private keys for configs without Passphrase* params.
+func WithPassphraseProvider(p SecretProvider) Option {
+	return func(d *Dialer) {
+		d.passphraseProvider = p
+	}
+}
+
+// WithSecretCommands enables PasswordCommand, PassphraseCommand and TOTPSecretCommand params.
+// They run a shell command from the address, so anyone, who controls the address (a DSN in a config file or an env variable),
+// can run arbitrary commands on this host. Enable them only for trusted addresses.
+// SecretCommand providers, set in code, don't need it.
+func WithSecretCommands() Option {
+	return func(d *Dialer) {
+		d.secretCommands = true
+	}
+}
+
+const defaultSecretTTL = time.Minute
+
+// Password*, Passphrase* and TOTPSecret* params in the order of precedence
+var (
+	passwordParams   = []string{"PasswordFile", "PasswordEnv", "PasswordCommand"}
+	passphraseParams = []string{"PassphraseFile", "PassphraseEnv", "PassphraseCommand"}
+	totpParams       = []string{"TOTPSecretFile", "TOTPSecretEnv", "TOTPSecretCommand"}
+)
+
+// secretFromParams returns the provider of the first set param, or nil.
+// The result is cached by d for SecretTTL.
+func (d *Dialer) secretFromParams(config Config, names []string, home string) (SecretProvider, error) {
+	var errs []error
+	for _, name := range names {
+		vals := config.Params[name]
+		switch {
+		case len(vals) == 0:
+			continue
+		case len(vals) > 1:
+			errs = append(errs, fmt.Errorf("multiple values for %s", name))
+			continue
+		}
+		if isSecretCommand(name) && !d.secretCommands {
+			errs = append(errs, fmt.Errorf("%s is disabled, see dial.WithSecretCommands", name))
+			continue
+		}
+		ttl, err := parseSecretTTL(config.Params)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		var p SecretProvider
+		switch name {
+		case "PasswordFile", "PassphraseFile", "TOTPSecretFile":
+			p = SecretFile(expandHome(vals[0], home))
+		case "PasswordEnv", "PassphraseEnv", "TOTPSecretEnv":
+			p = SecretEnv(vals[0])
+		default:
+			p = SecretCommand(vals[0])
+		}
+		return &cachedSecret{cache: d.secrets, key: name + "=" + vals[0], ttl: ttl, clock: d.clock, provider: p}, errors.Join(errs...)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func isSecretCommand(name string) bool {
+	return strings.HasSuffix(name, "Command")
+}
+
+// isAuthError checks, if the handshake has failed, because no auth method was accepted.
+// x/crypto has no error type for it.
+func isAuthError(err error) bool {
+	return strings.Contains(err.Error(), "ssh: unable to authenticate")
+}
+
+func parseSecretTTL(params map[string][]string) (time.Duration, error) {
+	vals := params["SecretTTL"]
+	switch len(vals) {
+	case 0:
+		return defaultSecretTTL, nil
+	case 1:
+	default:
+		return defaultSecretTTL, errors.New("multiple values for SecretTTL")
+	}
+	ttl, err := strconv.ParseUint(vals[0], 10, 32)
+	if err != nil {
+		return defaultSecretTTL, fmt.Errorf("invalid value for SecretTTL: %w", err)
+	}
+	return time.Duration(ttl) * time.Second, nil
+}
+
+// secretCache is shared by all configs of a Dialer, keyed by the secret source
+type secretCache struct {
+	mu sync.Mutex
+	m  map[string]secretCacheEntry
+}
+
+type secretCacheEntry struct {
+	val     string
+	expires time.Time
+}
+
+func newSecretCache() *secretCache {
+	return &secretCache{m: make(map[string]secretCacheEntry)}
+}
+
+type cachedSecret struct {
+	cache    *secretCache
+	key      string
+	ttl      time.Duration
+	clock    Clock
+	provider SecretProvider
+}
+
+func (s *cachedSecret) Secret(ctx context.Context) (string, error) {
+	if s.ttl <= 0 {
+		return s.provider.Secret(ctx)
+	}
+	s.cache.mu.Lock()
+	e, has := s.cache.m[s.key]
+	s.cache.mu.Unlock()
+	if has && s.clock.Now().Before(e.expires) {
+		return e.val, nil
+	}
+
+	val, err := s.provider.Secret(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.cache.mu.Lock()
+	s.cache.m[s.key] = secretCacheEntry{val: val, expires: s.clock.Now().Add(s.ttl)}
+	s.cache.mu.Unlock()
+	return val, nil
+}
+
+// invalidate drops the cached value, e.g. after failed authentication, it may be rotated
+func (s *cachedSecret) invalidate() {
+	s.cache.mu.Lock()
+	delete(s.cache.m, s.key)
+	s.cache.mu.Unlock()
+}
+
+// authSecrets are providers for makeSshAuth, nil if not configured
+type authSecrets struct {
+	password   SecretProvider
+	passphrase SecretProvider
+	// challenge answers keyboard-interactive prompts
+	challenge ChallengeResponder
+	// totp is the secret of TOTP codes
+	totp SecretProvider
+}
+
+func (d *Dialer) authSecrets(config Config, home string) (authSecrets, error) {
+	var res authSecrets
+	password, passwordErr := d.secretFromParams(config, passwordParams, home)
+	switch {
+	case config.Password != nil:
+	case password != nil:
+		res.password = password
+	default:
+		res.password = d.passwordProvider
+	}
+	passphrase, passphraseErr := d.secretFromParams(config, passphraseParams, home)
+	if passphrase != nil {
+		res.passphrase = passphrase
+	} else {
+		res.passphrase = d.passphraseProvider
+	}
+	totpSecret, totpErr := d.secretFromParams(config, totpParams, home)
+	res.totp = totpSecret
+	res.challenge = d.challengeResponder
+	if res.challenge == nil {
+		password := res.password
+		if config.Password != nil {
+			value := *config.Password
+			password = SecretFunc(func(context.Context) (string, error) { return value, nil })
+		}
+		res.challenge = defaultResponder(password, totpSecret)
+	}
+	return res, errors.Join(passwordErr, passphraseErr, totpErr)
+}
+
+// invalidate drops cached secrets, so the next handshake reads them again
+func (s authSecrets) invalidate() {
+	for _, p := range []SecretProvider{s.password, s.passphrase, s.totp} {
+		if c, ok := p.(*cachedSecret); ok {
+			c.invalidate()
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package dial
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSecretProviders(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secret")
+	if err := os.WriteFile(file, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MYTUNNEL_TEST_SECRET", "env-secret")
+
+	tests := []struct {
+		name     string
+		provider SecretProvider
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "file",
+			provider: SecretFile(file),
+			want:     "file-secret",
+		},
+		{
+			name:     "missing file",
+			provider: SecretFile(filepath.Join(dir, "missing")),
+			wantErr:  true,
+		},
+		{
+			name:     "env",
+			provider: SecretEnv("MYTUNNEL_TEST_SECRET"),
+			want:     "env-secret",
+		},
+		{
+			name:     "unset env",
+			provider: SecretEnv("MYTUNNEL_TEST_UNSET"),
+			wantErr:  true,
+		},
+		{
+			name:     "command",
+			provider: SecretCommand("echo cmd-secret"),
+			want:     "cmd-secret",
+		},
+		{
+			name:     "failed command",
+			provider: SecretCommand("exit 1"),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && tt.name == "command" {
+				t.Skip("echo adds a space on windows")
+			}
+			got, err := tt.provider.Secret(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Secret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Secret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedSecret(t *testing.T) {
+	clock := newFakeClock()
+	calls := 0
+	provider := SecretFunc(func(ctx context.Context) (string, error) {
+		calls++
+		return "secret", nil
+	})
+	cached := &cachedSecret{cache: newSecretCache(), key: "key", ttl: time.Minute, clock: clock, provider: provider}
+
+	secret := func(wantCalls int) {
+		t.Helper()
+		got, err := cached.Secret(context.Background())
+		if err != nil || got != "secret" {
+			t.Fatalf("Secret() = %q, %v", got, err)
+		}
+		if calls != wantCalls {
+			t.Errorf("provider calls = %d, want %d", calls, wantCalls)
+		}
+	}
+
+	secret(1)
+	secret(1)
+	clock.Advance(time.Minute)
+	secret(2)
+	cached.invalidate()
+	secret(3)
+
+	cached.ttl = 0
+	secret(4)
+	secret(5)
+}
@@ -0,0 +1,80 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Session is an ssh session on a pooled ssh client. No target address is required to open it.
+// Close must be called to release the client.
+type Session struct {
+	*ssh.Session
+	release func() error
+	close   sync.Once
+}
+
+// errAgentForwardingDenied is returned, if the server refuses ForwardAgent. The client is still valid.
+var errAgentForwardingDenied = errors.New("agent forwarding request denied")
+
+func NewSession(ctx context.Context, addr string) (*Session, error) {
+	return defaultDialer.NewSession(ctx, addr)
+}
+
+func NewSessionConfig(ctx context.Context, config Config) (*Session, error) {
+	return defaultDialer.NewSessionConfig(ctx, config)
+}
+
+// NewSession opens a session on the same client, which is used for connections to addr.
+// The target part of addr is ignored.
+func (d *Dialer) NewSession(ctx context.Context, addr string) (*Session, error) {
+	config, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.NewSessionConfig(ctx, config)
+}
+
+func (d *Dialer) NewSessionConfig(ctx context.Context, config Config) (*Session, error) {
+	config = config.withEnv(d.lookupEnv)
+	if err := errors.Join(config.canConnect()...); err != nil {
+		return nil, wrapErr(err)
+	}
+	forward := useForwardAgent(config.Params)
+	session, release, err := openOnClient(ctx, d, config, func(ctx context.Context, cli sshClient) (*ssh.Session, error) {
+		session, err := cli.NewSession()
+		if err != nil || !forward {
+			return session, err
+		}
+		// agent.RequestAgentForwarding doesn't tell a refusal from a broken client
+		ok, err := session.SendRequest("auth-agent-req@openssh.com", true, nil)
+		if err == nil && !ok {
+			err = errAgentForwardingDenied
+		}
+		if err != nil {
+			_ = session.Close()
+			return nil, err
+		}
+		return session, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Session{Session: session, release: release}, nil
+}
+
+func (s *Session) Close() error {
+	err := s.Session.Close()
+	if err == io.EOF {
+		// already closed by the server after the command has exited
+		err = nil
+	}
+	var releaseErr error
+	s.close.Do(func() {
+		releaseErr = s.release()
+	})
+	return errors.Join(err, releaseErr)
+}
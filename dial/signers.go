@@ -0,0 +1,76 @@
+package dial
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SignerProvider returns private keys for publickey auth.
+// It is called at handshake time, so keys from a secret manager can be rotated without a restart.
+type SignerProvider interface {
+	Signers(ctx context.Context) ([]ssh.Signer, error)
+}
+
+// SignerFunc is an adapter to use ordinary functions as SignerProvider.
+type SignerFunc func(ctx context.Context) ([]ssh.Signer, error)
+
+func (f SignerFunc) Signers(ctx context.Context) ([]ssh.Signer, error) {
+	return f(ctx)
+}
+
+// WithSigners adds keys for publickey auth of all configs.
+// Dialer keys are offered before key files. Options accumulate.
+func WithSigners(signers ...ssh.Signer) Option {
+	return WithSignerProvider(SignerFunc(func(context.Context) ([]ssh.Signer, error) {
+		return signers, nil
+	}))
+}
+
+// WithPrivateKeyPEM adds a PEM encoded private key, e.g. the content of id_ed25519.
+// Encrypted keys are decrypted with Passphrase* params or WithPassphraseProvider.
+func WithPrivateKeyPEM(pem []byte) Option {
+	return func(d *Dialer) {
+		d.keys = append(d.keys, dialerKey{pem: pem})
+	}
+}
+
+// WithSignerProvider adds a provider of keys for publickey auth of all configs, see WithSigners.
+func WithSignerProvider(p SignerProvider) Option {
+	return func(d *Dialer) {
+		d.keys = append(d.keys, dialerKey{provider: p})
+	}
+}
+
+// dialerKey is a PEM key or a provider. PEM is parsed at handshake time, so the passphrase of the config is used.
+type dialerKey struct {
+	pem      []byte
+	provider SignerProvider
+}
+
+// dialerSource is HandshakeInfo.KeySource of Dialer keys
+const dialerSource = "dialer"
+
+// appendDialerSigners resolves keys of WithSigners, WithPrivateKeyPEM and WithSignerProvider
+func appendDialerSigners(ctx context.Context, ids []identity, errs []error, dialerKeys []dialerKey, keys keyReader) ([]identity, []error) {
+	for i, key := range dialerKeys {
+		if key.provider == nil {
+			signer, err := keys.parse(key.pem, fmt.Sprintf("dialer key #%d", i+1))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			ids = append(ids, identity{signer: signer, source: dialerSource})
+			continue
+		}
+		signers, err := key.provider.Signers(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("dialer keys: %w", err))
+		}
+		for _, signer := range signers {
+			ids = append(ids, identity{signer: signer, source: dialerSource})
+		}
+	}
+	return ids, errs
+}
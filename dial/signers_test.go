@@ -0,0 +1,130 @@
+package dial
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialContextDialerKeys(t *testing.T) {
+	userKey, err := dialtest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := ssh.NewPublicKey(userKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	userSigner, err := ssh.NewSignerFromKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marshal := func(passphrase string) []byte {
+		var block *pem.Block
+		if passphrase == "" {
+			block, err = ssh.MarshalPrivateKey(userKey, "")
+		} else {
+			block, err = ssh.MarshalPrivateKeyWithPassphrase(userKey, "", []byte(passphrase))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(block)
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		AuthorizedKeys: map[string][]ssh.PublicKey{"key_user": {userPub}},
+	})
+	srv.SetupHome(t, nil)
+	// no home, like in a container
+	t.Setenv("HOME", "")
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte(srv.KnownHostsLine()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MYTUNNEL_TEST_PASSPHRASE", "passphrase")
+	echo := listenEcho(t, "tcp", "127.0.0.1:0")
+	addr := fmt.Sprintf("key_user@%s/%s?UserKnownHostsFile=%s", srv.Addr, echo, url.QueryEscape(knownHosts))
+
+	tests := []struct {
+		name    string
+		addr    string
+		opts    []Option
+		wantErr bool
+	}{
+		{
+			name: "signers",
+			addr: addr,
+			opts: []Option{WithSigners(userSigner)},
+		},
+		{
+			name: "pem",
+			addr: addr,
+			opts: []Option{WithPrivateKeyPEM(marshal(""))},
+		},
+		{
+			name: "encrypted pem",
+			addr: addr + "&PassphraseEnv=MYTUNNEL_TEST_PASSPHRASE",
+			opts: []Option{WithPrivateKeyPEM(marshal("passphrase"))},
+		},
+		{
+			name: "provider",
+			addr: addr,
+			opts: []Option{WithSignerProvider(SignerFunc(func(ctx context.Context) ([]ssh.Signer, error) {
+				return []ssh.Signer{userSigner}, nil
+			}))},
+		},
+		{
+			name: "failed provider and signers",
+			addr: addr,
+			opts: []Option{
+				WithSignerProvider(SignerFunc(func(ctx context.Context) ([]ssh.Signer, error) {
+					return nil, errors.New("secret manager is down")
+				})),
+				WithSigners(userSigner),
+			},
+		},
+		{
+			name:    "no keys",
+			addr:    addr,
+			wantErr: true,
+		},
+		{
+			name:    "no known hosts without home",
+			addr:    fmt.Sprintf("key_user@%s/%s", srv.Addr, echo),
+			opts:    []Option{WithSigners(userSigner)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info HandshakeInfo
+			ctx := WithTrace(testCtx(t), &Trace{
+				HandshakeDone: func(i HandshakeInfo, err error) {
+					info = i
+				},
+			})
+			conn, err := NewDialer(tt.opts...).DialContext(ctx, tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DialContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if info.KeySource != dialerSource {
+				t.Errorf("KeySource = %q, want %q", info.KeySource, dialerSource)
+			}
+			assertEcho(t, conn)
+			if err = conn.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
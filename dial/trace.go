@@ -0,0 +1,43 @@
+package dial
+
+import (
+	"context"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Trace is a set of hooks to run at stages of dialing, like httptrace.ClientTrace.
+// Any hook may be nil. Hooks of a new ssh client run only, if the client is not taken from the pool.
+type Trace struct {
+	// ConnectDone is called, when TCP connection to the ssh server is established or failed.
+	ConnectDone func(addr string, err error)
+	// HandshakeDone is called, when ssh handshake and authentication are finished.
+	HandshakeDone func(info HandshakeInfo, err error)
+	// ChannelOpenDone is called, when a channel to the target is opened or rejected.
+	ChannelOpenDone func(network, addr string, err error)
+}
+
+// HandshakeInfo describes ssh client connection.
+type HandshakeInfo struct {
+	ServerVersion string
+	// AuthMethod is the auth method, which has succeeded: "password", "keyboard-interactive" or "publickey".
+	// After a failed handshake, it is the last tried one.
+	AuthMethod string
+	// PublicKey is the accepted key for "publickey" method.
+	PublicKey ssh.PublicKey
+	// KeySource is the file of PublicKey or "agent".
+	KeySource string
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context, which makes dial functions call the hooks of trace.
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// ContextTrace returns the Trace associated with ctx, or nil.
+func ContextTrace(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceKey{}).(*Trace)
+	return trace
+}
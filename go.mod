@@ -5,10 +5,12 @@ go 1.24.0
 require (
 	github.com/AlekSi/pointer v1.2.0
 	github.com/go-sql-driver/mysql v1.8.1
+	github.com/pkg/sftp v1.13.10
 	golang.org/x/crypto v0.45.0
 )
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 )
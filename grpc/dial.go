@@ -0,0 +1,59 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/TelpeNight/mytunnel/dial"
+)
+
+var ErrTargetNotAllowed = errors.New("mytunnel/grpc: tunnel must not contain target address")
+
+// ContextDialer returns a function for grpc.WithContextDialer:
+//
+//	dialer, err := grpc.ContextDialer("ssh_user@example.com?ServerAliveInterval=10")
+//	conn, err := grpc.NewClient("dns:///10.0.0.5:50051", grpc.WithContextDialer(dialer), ...)
+//
+// The ssh hop comes from the tunnel, which must not contain a target address.
+// The target comes from the gRPC resolver and is dialed from the ssh host.
+// With passthrough resolver, hostnames are resolved on the ssh host.
+// unix:///path targets are dialed as unix sockets on the ssh host, abstract and relative ones are not supported.
+func ContextDialer(tunnel string) (func(ctx context.Context, addr string) (net.Conn, error), error) {
+	config, err := dial.ParseAddr(tunnel)
+	if err != nil {
+		return nil, err
+	}
+	if config.Net != "" || config.Addr != "" {
+		return nil, ErrTargetNotAllowed
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		config := config
+		config.Net, config.Addr, err = parseTarget(addr)
+		if err != nil {
+			return nil, err
+		}
+		return dial.DialConfig(ctx, config)
+	}, nil
+}
+
+// parseTarget maps the address from gRPC to the target. With a custom dialer,
+// gRPC passes unix targets as unix:///path, other addresses are host:port.
+func parseTarget(addr string) (string, string, error) {
+	if strings.HasPrefix(addr, "\x00") {
+		return "", "", fmt.Errorf("mytunnel/grpc: abstract unix sockets are not supported: %q", addr)
+	}
+	path, isUnix := strings.CutPrefix(addr, "unix://")
+	if !isUnix {
+		path, isUnix = strings.CutPrefix(addr, "unix:")
+	}
+	if !isUnix {
+		return "tcp", addr, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("mytunnel/grpc: unix socket path must be absolute on the ssh host: %q", addr)
+	}
+	return "unix", path, nil
+}
@@ -0,0 +1,133 @@
+package grpc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+)
+
+func TestContextDialer(t *testing.T) {
+	tests := []struct {
+		name    string
+		tunnel  string
+		wantErr bool
+	}{
+		{
+			name:   "user@host",
+			tunnel: "user@host",
+		},
+		{
+			name:   "params",
+			tunnel: "user@host:2222?ServerAliveInterval=10",
+		},
+		{
+			name:    "target",
+			tunnel:  "user@host/127.0.0.1:50051",
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			tunnel:  "user@host:port",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ContextDialer(tt.tunnel)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ContextDialer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseTarget(t *testing.T) {
+	tests := []struct {
+		addr     string
+		wantNet  string
+		wantAddr string
+		wantErr  bool
+	}{
+		{addr: "10.0.0.5:50051", wantNet: "tcp", wantAddr: "10.0.0.5:50051"},
+		{addr: "grpc.internal:50051", wantNet: "tcp", wantAddr: "grpc.internal:50051"},
+		{addr: "unix:///run/grpc.sock", wantNet: "unix", wantAddr: "/run/grpc.sock"},
+		{addr: "unix:/run/grpc.sock", wantNet: "unix", wantAddr: "/run/grpc.sock"},
+		{addr: "unix://grpc.sock", wantErr: true},
+		{addr: "\x00abstract", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			gotNet, gotAddr, err := parseTarget(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotNet != tt.wantNet || gotAddr != tt.wantAddr {
+				t.Errorf("parseTarget() = %q, %q, want %q, %q", gotNet, gotAddr, tt.wantNet, tt.wantAddr)
+			}
+		})
+	}
+}
+
+func TestContextDialerDial(t *testing.T) {
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+	})
+	srv.SetupHome(t, nil)
+	tcpEcho := listenEcho(t, "tcp", "127.0.0.1:0")
+	unixEcho := listenEcho(t, "unix", filepath.Join(t.TempDir(), "grpc.sock"))
+
+	dialer, err := ContextDialer("user:pass@" + srv.Addr)
+	if err != nil {
+		t.Fatalf("ContextDialer() error = %v", err)
+	}
+	// open connections keep the pooled ssh client, so both targets share it
+	for _, addr := range []string{tcpEcho, "unix://" + unixEcho} {
+		conn, err := dialer(t.Context(), addr)
+		if err != nil {
+			t.Fatalf("dialer(%q) error = %v", addr, err)
+		}
+		defer func() { _ = conn.Close() }()
+		assertEcho(t, conn)
+	}
+	if srv.Handshakes() != 1 {
+		t.Errorf("Handshakes() = %d, want 1", srv.Handshakes())
+	}
+}
+
+func listenEcho(t *testing.T, network, addr string) string {
+	t.Helper()
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	msg := fmt.Sprintf("hello %s", conn.RemoteAddr())
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != msg {
+		t.Fatalf("ReadFull() = %q, %v, want %q", buf, err, msg)
+	}
+}
@@ -0,0 +1,138 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial"
+)
+
+// autoAddr is the target of the auto mode: ssh_user@example.com/auto
+const autoAddr = "/auto"
+
+type autoTarget struct {
+	net, addr string
+}
+
+// autoTargets are probed in order, the first one, which answers with a mysql handshake, wins.
+var autoTargets = []autoTarget{
+	{"unix", "/var/run/mysqld/mysqld.sock"},
+	{"unix", "/run/mysqld/mysqld.sock"},
+	{"unix", "/tmp/mysql.sock"},
+	{"unix", "/var/lib/mysql/mysql.sock"},
+	{"unix", "/var/run/mysql/mysql.sock"},
+	{"tcp", "127.0.0.1:3306"},
+	{"tcp", "[::1]:3306"},
+}
+
+const probeTimeout = 3 * time.Second
+
+// autoCache maps tunnel (without target) to detected autoTarget
+var autoCache sync.Map
+
+func isAuto(config dial.Config) bool {
+	return config.Net == "unix" && config.Addr == autoAddr
+}
+
+func dialAuto(ctx context.Context, config dial.Config) (net.Conn, error) {
+	key := config
+	key.Net, key.Addr = "", ""
+	cacheKey := key.String()
+
+	if cached, has := autoCache.Load(cacheKey); has {
+		target := cached.(autoTarget)
+		config.Net, config.Addr = target.net, target.addr
+		conn, err := dial.DialConfig(ctx, config)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		// maybe server was reconfigured, detect again
+		autoCache.CompareAndDelete(cacheKey, target)
+	}
+
+	var errs []error
+	for _, target := range autoTargets {
+		config.Net, config.Addr = target.net, target.addr
+		conn, err := probe(ctx, config)
+		if err == nil {
+			autoCache.Store(cacheKey, target)
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("%s %s: %w", target.net, target.addr, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("mytunnel/mysql: can't detect target: %w", errors.Join(errs...))
+}
+
+func probe(ctx context.Context, config dial.Config) (net.Conn, error) {
+	conn, err := dial.DialConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	return readGreeting(ctx, conn)
+}
+
+var errNotMySQL = errors.New("not a mysql server")
+
+// readGreeting checks, that conn starts with a mysql handshake packet.
+// The returned conn replays consumed bytes, so it can be passed to the driver.
+// conn is closed on error.
+func readGreeting(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	// ssh channels don't support deadlines
+	var (
+		buf  = make([]byte, 5)
+		done = make(chan error, 1)
+	)
+	go func() {
+		_, err := io.ReadFull(conn, buf)
+		done <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		_ = conn.Close()
+		return nil, ctx.Err()
+	case err := <-done:
+		if err == nil && !isGreeting(buf) {
+			err = errNotMySQL
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return &replayConn{Conn: conn, prefix: bytes.NewReader(buf)}, nil
+	}
+}
+
+// isGreeting checks a packet header and the first payload byte:
+// protocol version 10 for handshake or 0xff for an error packet (server answers, but rejects us)
+func isGreeting(buf []byte) bool {
+	length := int(buf[0]) | int(buf[1])<<8 | int(buf[2])<<16
+	seq := buf[3]
+	return length > 0 && seq == 0 && (buf[4] == 10 || buf[4] == 0xff)
+}
+
+type replayConn struct {
+	net.Conn
+	prefix *bytes.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	if c.prefix.Len() > 0 {
+		return c.prefix.Read(b)
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,143 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+)
+
+func Test_readGreeting(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		wantErr bool
+	}{
+		{
+			name:   "handshake v10",
+			packet: []byte{0x4a, 0, 0, 0, 10, '8', '.', '0'},
+		},
+		{
+			name:   "error packet",
+			packet: []byte{0x10, 0, 0, 0, 0xff, 0x6a, 0x04},
+		},
+		{
+			name:    "ssh banner",
+			packet:  []byte("SSH-2.0-OpenSSH_9.6\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "wrong seq",
+			packet:  []byte{0x4a, 0, 0, 1, 10},
+			wantErr: true,
+		},
+		{
+			name:    "eof",
+			packet:  []byte{0x4a, 0},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer func() { _ = client.Close() }()
+			go func() {
+				_, _ = server.Write(tt.packet)
+				_ = server.Close()
+			}()
+
+			conn, err := readGreeting(context.Background(), client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readGreeting() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != string(tt.packet) {
+				t.Errorf("replayed = %v, want %v", got, tt.packet)
+			}
+		})
+	}
+}
+
+func Test_readGreetingTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := readGreeting(ctx, client); err == nil {
+		t.Fatalf("readGreeting() expected timeout error")
+	}
+	if _, err := client.Write([]byte{0}); err == nil {
+		t.Errorf("conn is not closed on timeout")
+	}
+}
+
+func Test_dialAuto(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		up     autoTarget
+		dialed []autoTarget
+	)
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+		Forward: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			target := autoTarget{network, addr}
+			dialed = append(dialed, target)
+			if target != up {
+				return nil, errors.New("connection refused")
+			}
+			client, server := net.Pipe()
+			go func() {
+				_, _ = server.Write([]byte{0x4a, 0, 0, 0, 10, '8', '.', '0'})
+				_, _ = io.Copy(io.Discard, server)
+			}()
+			return client, nil
+		},
+	})
+	srv.SetupHome(t, nil)
+	addr := fmt.Sprintf("user:pass@%s/auto", srv.Addr)
+
+	// check checks, which targets were dialed by the server since the previous call
+	check := func(wantUp autoTarget, want []autoTarget) {
+		t.Helper()
+		mu.Lock()
+		up, dialed = wantUp, nil
+		mu.Unlock()
+
+		conn, err := dialContext(t.Context(), addr)
+		if err != nil {
+			t.Fatalf("dialContext() error = %v", err)
+		}
+		_ = conn.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(dialed, want) {
+			t.Errorf("dialed targets = %v, want %v", dialed, want)
+		}
+	}
+
+	tcp := autoTarget{"tcp", "127.0.0.1:3306"}
+	unix := autoTargets[2]
+	check(unix, autoTargets[:3])
+	// cache hit
+	check(unix, autoTargets[2:3])
+	// the cached target fails, all targets are probed in order again
+	check(tcp, append(autoTargets[2:3:3], autoTargets[:6]...))
+	check(tcp, autoTargets[5:6])
+}
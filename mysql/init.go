@@ -2,7 +2,11 @@ package mysql
 
 import (
 	"context"
+	"database/sql/driver"
+	"fmt"
 	"net"
+	"strconv"
+	"sync"
 
 	"github.com/TelpeNight/mytunnel/dial"
 	"github.com/go-sql-driver/mysql"
@@ -13,11 +17,65 @@ func init() {
 }
 
 func dialContext(ctx context.Context, addr string) (net.Conn, error) {
-	normalized, err := normalizeAddr(addr)
+	config, err := dial.ParseAddr(addr)
 	if err != nil {
 		return nil, err
 	}
-	return dial.DialContext(ctx, normalized)
+	return dialConfig(ctx, normalizeConfig(config))
+}
+
+func dialConfig(ctx context.Context, config dial.Config) (net.Conn, error) {
+	if isAuto(config) {
+		return dialAuto(ctx, config)
+	}
+	return dial.DialConfig(ctx, config)
+}
+
+// connectorNets maps tunnels to dial functions, registered in the mysql driver.
+// The driver can't deregister a net, which may be used by open connectors, so registrations are shared by equal tunnels.
+var connectorNets = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// NewConnector returns a connector for sql.OpenDB, which dials through the tunnel.
+// Unlike DSN, tunnel config needs no escaping, so password and paths may contain any characters.
+//
+// cfg.Net and cfg.Addr are ignored, cfg itself is not modified. nil cfg means mysql.NewConfig().
+// A dial function is registered in the mysql driver once per distinct tunnel and is never removed,
+// so connectors for the same tunnel don't leak registrations.
+func NewConnector(tunnel dial.Config, cfg *mysql.Config) (driver.Connector, error) {
+	if cfg == nil {
+		cfg = mysql.NewConfig()
+	} else {
+		cfg = cfg.Clone()
+	}
+	tunnel = normalizeConfig(tunnel)
+
+	cfg.Net = connectorNet(tunnel)
+	cfg.Addr = tunnel.Addr
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("mytunnel/mysql: %w", err)
+	}
+	return connector, nil
+}
+
+// connectorNet returns the driver net, which dials the tunnel, registering it on the first use
+func connectorNet(tunnel dial.Config) string {
+	// URL is the complete escaped config
+	key := tunnel.URL()
+	connectorNets.Lock()
+	defer connectorNets.Unlock()
+	if name, has := connectorNets.m[key]; has {
+		return name
+	}
+	name := "ssh+tunnel+" + strconv.Itoa(len(connectorNets.m)+1)
+	mysql.RegisterDialContext(name, func(ctx context.Context, _ string) (net.Conn, error) {
+		return dialConfig(ctx, tunnel)
+	})
+	connectorNets.m[key] = name
+	return name
 }
 
 func normalizeAddr(addr string) (string, error) {
@@ -25,6 +83,10 @@ func normalizeAddr(addr string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return normalizeConfig(config).String(), nil
+}
+
+func normalizeConfig(config dial.Config) dial.Config {
 	if config.Net == "" {
 		config.Net = "tcp"
 	}
@@ -36,5 +98,5 @@ func normalizeAddr(addr string) (string, error) {
 			config.Addr = "/tmp/mysql.sock"
 		}
 	}
-	return config.String(), nil
+	return config
 }
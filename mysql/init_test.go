@@ -1,6 +1,17 @@
 package mysql
 
-import "testing"
+import (
+	"database/sql"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/AlekSi/pointer"
+	"github.com/TelpeNight/mytunnel/dial"
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+	"github.com/go-sql-driver/mysql"
+)
 
 func Test_normalizeAdd(t *testing.T) {
 	tests := []struct {
@@ -43,3 +54,97 @@ func Test_normalizeAdd(t *testing.T) {
 		})
 	}
 }
+
+func TestNewConnector(t *testing.T) {
+	cfg := mysql.NewConfig()
+	cfg.User = "db_user"
+	cfg.DBName = "database"
+
+	tunnel := dial.Config{
+		Username: "ssh_user",
+		Password: pointer.ToString("p@ss(a)/word?"),
+		Host:     "example.com",
+	}
+	connector, err := NewConnector(tunnel, cfg)
+	if err != nil {
+		t.Fatalf("NewConnector() error = %v", err)
+	}
+	if connector == nil {
+		t.Fatalf("NewConnector() returned nil connector")
+	}
+	if cfg.Net != "" || cfg.Addr != "" {
+		t.Errorf("NewConnector() modified cfg: Net = %q, Addr = %q", cfg.Net, cfg.Addr)
+	}
+
+	if _, err = NewConnector(tunnel, nil); err != nil {
+		t.Errorf("NewConnector() with nil cfg error = %v", err)
+	}
+}
+
+func Test_connectorNet(t *testing.T) {
+	tunnel := dial.Config{Username: "ssh_user", Password: pointer.ToString("pass"), Host: "example.com", Net: "tcp", Addr: "127.0.0.1:3306"}
+	first := connectorNet(tunnel)
+	if got := connectorNet(tunnel); got != first {
+		t.Errorf("connectorNet() for equal tunnel = %q, want %q", got, first)
+	}
+	other := tunnel
+	other.Password = pointer.ToString("other")
+	if got := connectorNet(other); got == first {
+		t.Errorf("connectorNet() for other password = %q, want a new net", got)
+	}
+}
+
+func TestNewConnectorDial(t *testing.T) {
+	const password = "p@ss(a)/word?"
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"ssh_user": password},
+	})
+	srv.SetupHome(t, nil)
+
+	// the target closes connections, it's enough to see, that the tunnel is authenticated
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			select {
+			case accepted <- struct{}{}:
+			default:
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	connector, err := NewConnector(dial.Config{
+		Username: "ssh_user",
+		Password: pointer.ToString(password),
+		Host:     srv.Host(),
+		Port:     srv.Port(),
+		Net:      "tcp",
+		Addr:     l.Addr().String(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewConnector() error = %v", err)
+	}
+	// the driver logs failed handshakes
+	_ = mysql.SetLogger(log.New(io.Discard, "", 0))
+	db := sql.OpenDB(connector)
+	defer func() { _ = db.Close() }()
+	_ = db.PingContext(t.Context())
+
+	select {
+	case <-accepted:
+	default:
+		t.Fatalf("target has not been dialed, ssh handshakes = %d", srv.Handshakes())
+	}
+	if srv.Handshakes() == 0 {
+		t.Errorf("Handshakes() = 0, want password auth")
+	}
+}
@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial"
+)
+
+// DialFunc returns a function, that can be used as pgconn.DialFunc:
+//
+//	pgConfig.DialFunc, err = postgres.DialFunc("ssh_user@example.com/tmp/.s.PGSQL.5432")
+//
+// Every connection is dialed to the tunnel target. The network and address, passed by pgx, are ignored.
+func DialFunc(tunnel string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	normalized, err := normalizeAddr(tunnel)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dial.DialContext(ctx, normalized)
+	}, nil
+}
+
+// Dialer implements pq.Dialer and pq.DialerContext. Use it with pq.DialOpen or pq.NewConnector.
+type Dialer struct {
+	addr string
+}
+
+// NewDialer returns a lib/pq dialer for the tunnel address.
+// Every connection is dialed to the tunnel target. The network and address, passed by lib/pq, are ignored.
+func NewDialer(tunnel string) (*Dialer, error) {
+	normalized, err := normalizeAddr(tunnel)
+	if err != nil {
+		return nil, err
+	}
+	return &Dialer{addr: normalized}, nil
+}
+
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *Dialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d *Dialer) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	return dial.DialContext(ctx, d.addr)
+}
+
+func normalizeAddr(addr string) (string, error) {
+	addr = trimTunnelNet(addr)
+	config, err := dial.ParseAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	if config.Net == "" {
+		config.Net = "tcp"
+	}
+	if config.Addr == "" {
+		switch config.Net {
+		case "tcp":
+			config.Addr = "127.0.0.1:5432"
+		case "unix":
+			config.Addr = "/var/run/postgresql/.s.PGSQL.5432"
+		}
+	}
+	return config.String(), nil
+}
+
+// trimTunnelNet allows to copy the host spec from mysql DSN as is: ssh+tunnel(...)
+func trimTunnelNet(addr string) string {
+	inner, ok := strings.CutPrefix(addr, "ssh+tunnel(")
+	if !ok {
+		return addr
+	}
+	inner, ok = strings.CutSuffix(inner, ")")
+	if !ok {
+		return addr
+	}
+	return inner
+}
@@ -0,0 +1,134 @@
+package postgres
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+)
+
+func Test_normalizeAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			addr: "",
+			want: "/127.0.0.1:5432",
+		},
+		{
+			name: "user@host",
+			addr: "user@host",
+			want: "user@host/127.0.0.1:5432",
+		},
+		{
+			name: "user@host/127.0.0.1:5433",
+			addr: "user@host/127.0.0.1:5433",
+			want: "user@host/127.0.0.1:5433",
+		},
+		{
+			name: "user@host/my.sock",
+			addr: "user@host/my.sock",
+			want: "user@host/my.sock",
+		},
+		{
+			name: "ssh+tunnel",
+			addr: "ssh+tunnel(user(a)host/my.sock?ServerAliveInterval=10)",
+			want: "user@host/my.sock?ServerAliveInterval=10",
+		},
+		{
+			name:    "ssh+tunnel no user",
+			addr:    "ssh+tunnel(@host)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeAddr(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("normalizeAddr() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("normalizeAddr() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialers(t *testing.T) {
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+	})
+	srv.SetupHome(t, nil)
+	target := listenEcho(t)
+	tunnel := fmt.Sprintf("user:pass@%s/%s", srv.Addr, target)
+
+	dialFunc, err := DialFunc(tunnel)
+	if err != nil {
+		t.Fatalf("DialFunc() error = %v", err)
+	}
+	dialer, err := NewDialer(tunnel)
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+	// the address from the driver is ignored, all connections share the pooled ssh client
+	dials := map[string]func() (net.Conn, error){
+		"DialFunc": func() (net.Conn, error) { return dialFunc(t.Context(), "tcp", "127.0.0.1:1") },
+		"Dial":     func() (net.Conn, error) { return dialer.Dial("tcp", "127.0.0.1:1") },
+		"DialTimeout": func() (net.Conn, error) {
+			return dialer.DialTimeout("tcp", "127.0.0.1:1", 5*time.Second)
+		},
+	}
+	for name, dial := range dials {
+		conn, err := dial()
+		if err != nil {
+			t.Fatalf("%s() error = %v", name, err)
+		}
+		defer func() { _ = conn.Close() }()
+		assertEcho(t, conn)
+	}
+	if srv.Handshakes() != 1 {
+		t.Errorf("Handshakes() = %d, want 1", srv.Handshakes())
+	}
+}
+
+func listenEcho(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	const msg = "hello"
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != msg {
+		t.Fatalf("ReadFull() = %q, %v, want %q", buf, err, msg)
+	}
+}
@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/TelpeNight/mytunnel/dial"
+)
+
+// Dialer returns a function for go-redis Options.Dialer:
+//
+//	opts.Dialer, err = redis.Dialer("ssh_user@example.com/var/run/redis/redis.sock")
+//
+// Every connection is dialed to the tunnel target. The network and address, passed by go-redis, are ignored.
+func Dialer(tunnel string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	normalized, err := normalizeAddr(tunnel)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dial.DialContext(ctx, normalized)
+	}, nil
+}
+
+var ErrTargetNotAllowed = errors.New("mytunnel/redis: node dialer tunnel must not contain target address")
+
+// NodeDialer returns a function for go-redis ClusterOptions.Dialer and FailoverOptions.Dialer.
+// Node addresses, discovered by go-redis, are dialed from the ssh host,
+// so all nodes share the same pooled ssh client. The tunnel must not contain a target address.
+// Hostnames are resolved on the ssh host.
+func NodeDialer(tunnel string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	config, err := dial.ParseAddr(tunnel)
+	if err != nil {
+		return nil, err
+	}
+	if config.Net != "" || config.Addr != "" {
+		return nil, ErrTargetNotAllowed
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		config := config
+		config.Net, config.Addr = network, addr
+		return dial.DialConfig(ctx, config)
+	}, nil
+}
+
+func normalizeAddr(addr string) (string, error) {
+	config, err := dial.ParseAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	if config.Net == "" {
+		config.Net = "tcp"
+	}
+	if config.Addr == "" {
+		switch config.Net {
+		case "tcp":
+			config.Addr = "127.0.0.1:6379"
+		case "unix":
+			config.Addr = "/var/run/redis/redis.sock"
+		}
+	}
+	return config.String(), nil
+}
@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+)
+
+func Test_normalizeAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			addr: "",
+			want: "/127.0.0.1:6379",
+		},
+		{
+			name: "user@host",
+			addr: "user@host",
+			want: "user@host/127.0.0.1:6379",
+		},
+		{
+			name: "user@host/127.0.0.1:6380",
+			addr: "user@host/127.0.0.1:6380",
+			want: "user@host/127.0.0.1:6380",
+		},
+		{
+			name: "user@host/redis.sock",
+			addr: "user@host/redis.sock",
+			want: "user@host/redis.sock",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeAddr(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("normalizeAddr() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("normalizeAddr() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialer(t *testing.T) {
+	srv := startServer(t)
+	target := listenEcho(t)
+
+	dialer, err := Dialer(fmt.Sprintf("user:pass@%s/%s", srv.Addr, target))
+	if err != nil {
+		t.Fatalf("Dialer() error = %v", err)
+	}
+	// the address from go-redis is ignored
+	conn, err := dialer(t.Context(), "tcp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("dialer() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	assertEcho(t, conn)
+}
+
+func TestNodeDialer(t *testing.T) {
+	if _, err := NodeDialer("user@host/127.0.0.1:6379"); err == nil {
+		t.Errorf("NodeDialer() expected error for tunnel with target")
+	}
+
+	srv := startServer(t)
+	nodes := []string{listenEcho(t), listenEcho(t)}
+	// params of a tunnel without target belong to the ssh hop
+	dialer, err := NodeDialer(fmt.Sprintf("user:pass@%s?ServerAliveInterval=10", srv.Addr))
+	if err != nil {
+		t.Fatalf("NodeDialer() error = %v", err)
+	}
+	for _, node := range nodes {
+		conn, err := dialer(t.Context(), "tcp", node)
+		if err != nil {
+			t.Fatalf("dialer(%s) error = %v", node, err)
+		}
+		defer func() { _ = conn.Close() }()
+		assertEcho(t, conn)
+	}
+	if srv.Handshakes() != 1 {
+		t.Errorf("Handshakes() = %d, want 1 for all nodes", srv.Handshakes())
+	}
+}
+
+func startServer(t *testing.T) *dialtest.Server {
+	t.Helper()
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+	})
+	srv.SetupHome(t, nil)
+	return srv
+}
+
+func listenEcho(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	const msg = "PING"
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != msg {
+		t.Fatalf("ReadFull() = %q, %v, want %q", buf, err, msg)
+	}
+}
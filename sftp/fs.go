@@ -0,0 +1,139 @@
+package sftp
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// FS returns a read-only fs.FS of the remote directory dir. Relative dir is resolved from the sftp working directory,
+// usually the home of the ssh user. The FS is valid until the client is closed.
+func (c *Client) FS(dir string) fs.FS {
+	return remoteFS{client: c.Client, dir: dir}
+}
+
+type remoteFS struct {
+	client *sftp.Client
+	dir    string
+}
+
+var (
+	_ fs.StatFS    = remoteFS{}
+	_ fs.ReadDirFS = remoteFS{}
+)
+
+func (f remoteFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if f.dir == "" {
+		return name, nil
+	}
+	return path.Join(f.dir, name), nil
+}
+
+func (f remoteFS) Open(name string) (fs.File, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.client.Stat(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathErr(err)}
+	}
+	if info.IsDir() {
+		return &remoteDir{fs: f, name: name, info: info}, nil
+	}
+	file, err := f.client.Open(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathErr(err)}
+	}
+	return file, nil
+}
+
+func (f remoteFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.client.Stat(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: unwrapPathErr(err)}
+	}
+	return info, nil
+}
+
+// ReadDir returns entries sorted by name, as fs.ReadDirFS requires
+func (f remoteFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := f.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := f.client.ReadDir(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: unwrapPathErr(err)}
+	}
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = fs.FileInfoToDirEntry(info)
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+// unwrapPathErr drops the remote path of sftp errors, the fs name is reported instead
+func unwrapPathErr(err error) error {
+	if pathErr, ok := err.(*fs.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
+
+// remoteDir is an opened directory. Entries are read on the first ReadDir call.
+type remoteDir struct {
+	fs      remoteFS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *remoteDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *remoteDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *remoteDir) Close() error {
+	return nil
+}
+
+func (d *remoteDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
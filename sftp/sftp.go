@@ -0,0 +1,145 @@
+// Package sftp opens sftp clients on pooled ssh clients of dial:
+//
+//	client, err := sftp.Open(ctx, "ssh_user@db.example.com")
+//	defer client.Close()
+//	err = client.Download(ctx, "/var/backups/dump.sql.gz", "dump.sql.gz")
+//
+// The client holds a reference to the pooled ssh client, like a tunnel connection,
+// so no handshake is needed, if a tunnel to the same address is open.
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/TelpeNight/mytunnel/dial"
+	"github.com/pkg/sftp"
+)
+
+// Client is an sftp client on a pooled ssh client. Close must be called to release the ssh client.
+type Client struct {
+	*sftp.Client
+	session *dial.Session
+}
+
+// Dialer opens sftp clients on the pooled ssh clients of a dial.Dialer.
+// Package level functions use the default dial.Dialer.
+type Dialer struct {
+	newSession func(ctx context.Context, config dial.Config) (*dial.Session, error)
+}
+
+func NewDialer(d *dial.Dialer) *Dialer {
+	return &Dialer{newSession: d.NewSessionConfig}
+}
+
+var defaultDialer = &Dialer{newSession: dial.NewSessionConfig}
+
+func Open(ctx context.Context, addr string) (*Client, error) {
+	return defaultDialer.Open(ctx, addr)
+}
+
+func OpenConfig(ctx context.Context, config dial.Config) (*Client, error) {
+	return defaultDialer.OpenConfig(ctx, config)
+}
+
+// Open opens an sftp client on the same ssh client, which is used for connections to addr.
+// The target part of addr is ignored.
+func (d *Dialer) Open(ctx context.Context, addr string) (*Client, error) {
+	config, err := dial.ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.OpenConfig(ctx, config)
+}
+
+func (d *Dialer) OpenConfig(ctx context.Context, config dial.Config) (*Client, error) {
+	session, err := d.newSession(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newClient(ctx, session)
+	if err != nil {
+		_ = session.Close()
+		return nil, fmt.Errorf("mytunnel/sftp: %w", err)
+	}
+	return &Client{Client: client, session: session}, nil
+}
+
+func newClient(ctx context.Context, session *dial.Session) (*sftp.Client, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	// sftp init has no context, closing the session breaks it
+	stop := context.AfterFunc(ctx, func() { _ = session.Close() })
+	defer stop()
+	if err = session.RequestSubsystem("sftp"); err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		err = ctxErr
+	}
+	return client, err
+}
+
+// Close closes the sftp client and releases the ssh client.
+func (c *Client) Close() error {
+	err := c.Client.Close()
+	return errors.Join(err, c.session.Close())
+}
+
+// Download copies the remote file to the local path. The local file is created or truncated.
+// If ctx is done, the copy is stopped, and the partial local file is left.
+func (c *Client) Download(ctx context.Context, remote, local string) error {
+	src, err := c.Client.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	dst, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	err = copyContext(ctx, src, func() (int64, error) { return src.WriteTo(dst) })
+	return errors.Join(err, dst.Close())
+}
+
+// Upload copies the local file to the remote path. The remote file is created or truncated.
+// If ctx is done, the copy is stopped, and the partial remote file is left.
+func (c *Client) Upload(ctx context.Context, local, remote string) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	dst, err := c.Client.Create(remote)
+	if err != nil {
+		return err
+	}
+	err = copyContext(ctx, dst, func() (int64, error) { return dst.ReadFrom(src) })
+	if err != nil {
+		_ = dst.Close()
+		return err
+	}
+	// remote write errors may be reported on close
+	return dst.Close()
+}
+
+// copyContext runs copy, closing the remote file, when ctx is done
+func copyContext(ctx context.Context, remote io.Closer, copy func() (int64, error)) error {
+	stop := context.AfterFunc(ctx, func() { _ = remote.Close() })
+	defer stop()
+	_, err := copy()
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/TelpeNight/mytunnel/dial"
+	"github.com/TelpeNight/mytunnel/dial/dialtest"
+)
+
+func TestClient(t *testing.T) {
+	remote := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(remote, "backups", "old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"backups/dump.sql":     "create table t;",
+		"backups/old/dump.sql": "drop table t;",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(remote, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := dialtest.Start(t, dialtest.Config{
+		Passwords: map[string]string{"user": "pass"},
+		SFTPDir:   remote,
+	})
+	srv.SetupHome(t, nil)
+	d := NewDialer(dial.NewDialer())
+	addr := fmt.Sprintf("user:pass@%s", srv.Addr)
+	client, err := d.Open(t.Context(), addr)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	t.Run("fs", func(t *testing.T) {
+		fsys := client.FS("backups")
+		if err := fstest.TestFS(fsys, "dump.sql", "old/dump.sql"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := fs.ReadFile(fsys, "dump.sql")
+		if err != nil || string(got) != files["backups/dump.sql"] {
+			t.Errorf("ReadFile() = %q, %v", got, err)
+		}
+		if _, err = fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat() error = %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("download and upload", func(t *testing.T) {
+		local := filepath.Join(t.TempDir(), "dump.sql")
+		if err := client.Download(t.Context(), "backups/dump.sql", local); err != nil {
+			t.Fatalf("Download() error = %v", err)
+		}
+		if err := client.Upload(t.Context(), local, "backups/copy.sql"); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+		got, err := os.ReadFile(filepath.Join(remote, "backups", "copy.sql"))
+		if err != nil || string(got) != files["backups/dump.sql"] {
+			t.Errorf("uploaded = %q, %v", got, err)
+		}
+	})
+
+	t.Run("pooled", func(t *testing.T) {
+		second, err := d.Open(t.Context(), addr)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		if err = second.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+		if n := srv.Handshakes(); n != 1 {
+			t.Errorf("Handshakes() = %d, want 1", n)
+		}
+	})
+}